@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/rnubel/pgmgr/pgmgr"
 	cli "github.com/urfave/cli"
@@ -26,13 +29,64 @@ func displayVersion(config *pgmgr.Config) error {
 	return displayErrorOrMessage(err, "Latest migration version:", v)
 }
 
+func displayStatus(config *pgmgr.Config) error {
+	statuses, err := pgmgr.Status(config)
+	if err != nil {
+		return displayErrorOrMessage(err)
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No migrations found in", config.MigrationFolder)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tORDER\tDOWN\tFILENAME\tNOTES") //nolint:errcheck
+	for _, s := range statuses {
+		state, order, down, filename, notes := "pending", "-", "no", s.Filename, ""
+		if s.Applied {
+			state = "applied"
+			order = fmt.Sprint(s.AppliedOrder)
+		}
+		if s.HasDown {
+			down = "yes"
+		}
+		if s.Orphaned {
+			filename = "-"
+			notes = "orphaned: no migration file found"
+		}
+		if s.Backdated {
+			notes = "backdated: older than current version"
+		}
+		if s.Skipped {
+			state = "skipped"
+			notes = "only for environments: " + strings.Join(s.Environments, ", ")
+		}
+		if notes == "" {
+			notes = s.Description
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", s.Version, state, order, down, filename, notes) //nolint:errcheck
+	}
+
+	return w.Flush()
+}
+
+func applyDryRunFlags(config *pgmgr.Config, c *cli.Context) {
+	if c.Bool("dry-run") || c.Bool("sql") {
+		config.DryRun = true
+	}
+	if c.Bool("sql") {
+		config.DryRunSQL = true
+	}
+}
+
 func main() {
 	config := &pgmgr.Config{}
 	app := cli.NewApp()
 
 	app.Name = "pgmgr"
 	app.Usage = "manage your app's Postgres database"
-	app.Version = "1.1.7"
+	app.Version = pgmgr.ToolVersion
 
 	var s []string
 
@@ -115,12 +169,53 @@ func main() {
 			Usage:  "folder containing the migrations to apply",
 			EnvVar: "PGMGR_MIGRATION_FOLDER",
 		},
+		cli.StringFlag{
+			Name:   "environment, e",
+			Value:  "",
+			Usage:  "the environment being migrated; migrations for other environments are skipped",
+			EnvVar: "PGMGR_ENV",
+		},
+		cli.StringSliceFlag{
+			Name:   "environment-folders",
+			Value:  (*cli.StringSlice)(&s),
+			Usage:  "environments whose migrations are in a folder of the same name inside the migration folder",
+			EnvVar: "PGMGR_ENVIRONMENT_FOLDERS",
+		},
 		cli.StringFlag{
 			Name:   "migration-driver",
 			Value:  "",
 			Usage:  "how to apply the migrations. supported options are pq (which will execute the migration as one statement) or psql (which will use the psql binary on your system to execute each line) (default: pq)",
 			EnvVar: "PGMGR_MIGRATION_DRIVER",
 		},
+		cli.StringFlag{
+			Name:   "out-of-order",
+			Value:  "",
+			Usage:  "what to do when migrating would apply a migration older than the latest applied one: allow, warn or error",
+			EnvVar: "PGMGR_OUT_OF_ORDER",
+		},
+		cli.StringFlag{
+			Name:   "lock-timeout",
+			Value:  "",
+			Usage:  "the default lock_timeout for each migration, e.g. 5s; migrations can override it with a '-- pgmgr:lock_timeout=' comment",
+			EnvVar: "PGMGR_LOCK_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "statement-timeout",
+			Value:  "",
+			Usage:  "the default statement_timeout for each migration, e.g. 10min; migrations can override it with a '-- pgmgr:statement_timeout=' comment",
+			EnvVar: "PGMGR_STATEMENT_TIMEOUT",
+		},
+		cli.BoolFlag{
+			Name:   "no-lock",
+			Usage:  "do not take an advisory lock while migrating or rolling back. Only use this if nothing else can migrate the database concurrently.",
+			EnvVar: "PGMGR_NO_LOCK",
+		},
+		cli.IntFlag{
+			Name:   "advisory-lock-timeout",
+			Value:  0,
+			Usage:  "seconds to wait for another pgmgr process to release the migration lock before giving up (default: wait forever)",
+			EnvVar: "PGMGR_ADVISORY_LOCK_TIMEOUT",
+		},
 		cli.BoolFlag{
 			Name:   "no-compress",
 			Usage:  "whether to skip compressing the database dump. See pg_dump -Z.",
@@ -171,6 +266,46 @@ func main() {
 
 				return displayErrorOrMessage(pgmgr.CreateMigration(config, c.Args()[0], c.Bool("no-txn")))
 			},
+			Subcommands: []cli.Command{
+				{
+					Name:  "squash",
+					Usage: "replaces every migration older than --before with a single baseline migration of the schema",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "before",
+							Usage: "squash every migration with a version lower than this one",
+						},
+						cli.StringFlag{
+							Name:  "archive-folder",
+							Usage: "where to move the squashed migration files (default: archive/ inside the migration folder)",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if !c.IsSet("before") {
+							return cli.NewExitError("the version to squash migrations before must be given with --before", 1)
+						}
+
+						return displayErrorOrMessage(pgmgr.Squash(config, c.Int64("before"), c.String("archive-folder")))
+					},
+				},
+				{
+					Name:  "convert-format",
+					Usage: "renames migrations to the format given by --to, and rewrites the versions in the migration table to match",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "to",
+							Usage: "the format to convert to: unix or datetime",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.String("to") == "" {
+							return cli.NewExitError("the format to convert to must be given with --to", 1)
+						}
+
+						return displayErrorOrMessage(pgmgr.ConvertFormat(config, c.String("to")), "Remember to set the format option to", c.String("to"))
+					},
+				},
+			},
 		},
 		{
 			Name:  "config",
@@ -226,11 +361,86 @@ func main() {
 						return displayVersion(config)
 					},
 				},
+				{
+					Name:  "verify",
+					Usage: "checks that applied migrations have not been modified since they were applied",
+					Action: func(c *cli.Context) error {
+						mismatches, err := pgmgr.Verify(config)
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						if len(mismatches) == 0 {
+							fmt.Println("All applied migrations match their recorded checksums.")
+							return nil
+						}
+
+						for _, m := range mismatches {
+							fmt.Printf("%s: recorded checksum %s, file checksum %s\n", m.Filename, m.Recorded, m.Actual)
+						}
+						return cli.NewExitError(fmt.Sprintln("Error:", len(mismatches), "applied migration(s) have been modified."), 1)
+					},
+				},
+				{
+					Name:  "status",
+					Usage: "lists every migration with whether it has been applied",
+					Action: func(c *cli.Context) error {
+						return displayStatus(config)
+					},
+				},
 				{
 					Name:  "migrate",
 					Usage: "applies any un-applied migrations in the migration folder (see --migration-folder)",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "to",
+							Usage: "migrate up or down to the given version instead of applying every migration",
+						},
+						cli.BoolFlag{
+							Name:  "allow-modified",
+							Usage: "migrate even if applied migrations have been modified since they were applied",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "list the migrations which would be applied, without applying them",
+						},
+						cli.BoolFlag{
+							Name:  "sql",
+							Usage: "print the SQL which would be run, without running it (implies --dry-run)",
+						},
+						cli.Int64Flag{
+							Name:  "fake",
+							Usage: "record the given version as applied without running it",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "with --fake, allow versions which have no migration file",
+						},
+						cli.BoolFlag{
+							Name:  "single-transaction",
+							Usage: "apply every migration in one transaction, so that nothing is applied if any fails",
+						},
+					},
 					Action: func(c *cli.Context) error {
-						err := pgmgr.Migrate(config)
+						if c.IsSet("fake") {
+							v := c.Int64("fake")
+							return displayErrorOrMessage(pgmgr.MarkApplied(config, v, c.Bool("force")), "Marked version", v, "as applied.")
+						}
+
+						if c.Bool("allow-modified") {
+							config.AllowModified = true
+						}
+						if c.Bool("single-transaction") {
+							config.SingleTransaction = true
+						}
+						applyDryRunFlags(config, c)
+
+						var err error
+						if c.IsSet("to") {
+							err = pgmgr.MigrateTo(config, c.Int64("to"))
+						} else {
+							err = pgmgr.Migrate(config)
+						}
 						if err != nil {
 							return cli.NewExitError(fmt.Sprintln("Error during migration:", err), 1)
 						}
@@ -241,8 +451,152 @@ func main() {
 				{
 					Name:  "rollback",
 					Usage: "rolls back the latest migration",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "steps",
+							Value: 1,
+							Usage: "number of migrations to roll back, most recently applied first",
+						},
+						cli.Int64Flag{
+							Name:  "to",
+							Usage: "roll back every migration applied after the given version",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "list the migrations which would be rolled back, without rolling them back",
+						},
+						cli.BoolFlag{
+							Name:  "sql",
+							Usage: "print the SQL which would be run, without running it (implies --dry-run)",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						applyDryRunFlags(config, c)
+
+						if c.IsSet("to") && c.IsSet("steps") {
+							return cli.NewExitError("only one of --to and --steps may be given", 1)
+						}
+
+						if c.IsSet("to") {
+							return displayErrorOrMessage(pgmgr.RollbackTo(config, c.Int64("to")))
+						}
+
+						return displayErrorOrMessage(pgmgr.RollbackSteps(config, c.Int("steps")))
+					},
+				},
+				{
+					Name:  "script",
+					Usage: "prints a psql script which migrates the database between two versions, for applying by hand",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "from",
+							Value: -1,
+							Usage: "the highest version applied to the database; the script fails if it is not (default: no migrations applied)",
+						},
+						cli.Int64Flag{
+							Name:  "to",
+							Usage: "the version to migrate to (default: the latest migration)",
+						},
+						cli.BoolFlag{
+							Name:  "rollback",
+							Usage: "print a script which rolls back from --from to --to instead",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						var err error
+						if c.Bool("rollback") {
+							if !c.IsSet("from") {
+								return cli.NewExitError("--from must be given for a rollback script", 1)
+							}
+
+							to := int64(-1)
+							if c.IsSet("to") {
+								to = c.Int64("to")
+							}
+							err = pgmgr.RollbackScript(config, os.Stdout, c.Int64("from"), to)
+						} else {
+							to := int64(math.MaxInt64)
+							if c.IsSet("to") {
+								to = c.Int64("to")
+							}
+							err = pgmgr.Script(config, os.Stdout, c.Int64("from"), to)
+						}
+
+						if err != nil {
+							return cli.NewExitError(fmt.Sprintln("Error: ", err), 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "baseline",
+					Usage: "adopts an existing database by recording migrations up to --version as applied, without running them",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "version",
+							Usage: "the latest migration which the database's schema already reflects",
+						},
+						cli.BoolFlag{
+							Name:  "verify-schema",
+							Usage: "first check that the database's schema matches the dump file (see --dump-file)",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if !c.IsSet("version") {
+							return cli.NewExitError("the version to baseline at must be given with --version", 1)
+						}
+
+						v := c.Int64("version")
+						return displayErrorOrMessage(pgmgr.Baseline(config, v, c.Bool("verify-schema")), "Database baselined at version", v)
+					},
+				},
+				{
+					Name:  "unapply",
+					Usage: "removes a version from the migration table without rolling it back (requires --fake)",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "fake",
+							Usage: "the version to record as not applied",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "allow versions which have no migration file",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if !c.IsSet("fake") {
+							return cli.NewExitError("the version to unapply must be given with --fake; use `pgmgr db rollback` to revert migrations", 1)
+						}
+
+						v := c.Int64("fake")
+						return displayErrorOrMessage(pgmgr.MarkUnapplied(config, v, c.Bool("force")), "Marked version", v, "as not applied.")
+					},
+				},
+				{
+					Name:  "upgrade-table",
+					Usage: "converts the migration table's version column to --column-type, and adds any missing columns",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "column-type",
+							Usage: "the column type to convert the version column to: integer or string (default: the configured column-type)",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return displayErrorOrMessage(pgmgr.UpgradeTable(config, c.String("column-type")), "Migration table is up to date.")
+					},
+				},
+				{
+					Name:  "redo",
+					Usage: "rolls back the latest migration and applies it again",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "steps",
+							Value: 1,
+							Usage: "number of migrations to roll back and re-apply",
+						},
+					},
 					Action: func(c *cli.Context) error {
-						return displayErrorOrMessage(pgmgr.Rollback(config))
+						return displayErrorOrMessage(pgmgr.Redo(config, c.Int("steps")))
 					},
 				},
 			},
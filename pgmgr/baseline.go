@@ -0,0 +1,69 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Baseline adopts an existing database which pgmgr has not managed before.
+// It creates the migration table and records every migration up to and
+// including the given version as applied, without running any of them. If
+// verifySchema is set, it first checks that the database's schema matches
+// the dump file, and changes nothing if it does not.
+func Baseline(c *Config, version int64, verifySchema bool) error {
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return err
+	}
+	ups = forEnvironment(c, ups)
+
+	if !containsVersion(ups, version) {
+		return fmt.Errorf("no migration found with version %d", version)
+	}
+
+	if verifySchema {
+		if err := verifySchemaMatchesDump(c); err != nil {
+			return err
+		}
+	}
+
+	return withSession(c, func(db *sql.DB) error {
+		if err := initialize(c, db); err != nil {
+			return err
+		}
+
+		applied, err := appliedVersions(c, db)
+		if err != nil {
+			return err
+		}
+
+		isApplied := map[int64]bool{}
+		for _, v := range applied {
+			isApplied[v] = true
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range ups {
+			if m.Version > version || isApplied[m.Version] {
+				continue
+			}
+
+			contents, err := readMigration(c, m)
+			if err == nil {
+				err = insertSchemaVersion(c, tx, m.Version, checksum(contents), notExecuted)
+			}
+			if err != nil {
+				tx.Rollback() //nolint:errcheck // already returning an error
+				return err
+			}
+
+			fmt.Println("== Marked", m.Filename, "as applied ==")
+		}
+
+		return tx.Commit()
+	})
+}
@@ -0,0 +1,90 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UpgradeTable brings the migration table up to date: it converts the
+// version column to the given column type ("integer" or "string"; default:
+// the configured ColumnType), keeping every row, and adds any migration
+// detail columns which are missing. The table is created if it doesn't
+// exist yet.
+func UpgradeTable(c *Config, columnType string) error {
+	if columnType == "" {
+		columnType = c.columnType()
+	}
+	if columnType != "integer" && columnType != "string" {
+		return errors.New(`column type must be "integer" or "string"`)
+	}
+
+	upgraded := *c
+	upgraded.ColumnType = columnType
+
+	return withSession(&upgraded, func(db *sql.DB) error {
+		exists, err := migrationTableExists(&upgraded, db)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return initialize(&upgraded, db)
+		}
+
+		current, dataType, err := versionColumnType(&upgraded, db)
+		if err != nil {
+			return err
+		}
+
+		if current != columnType {
+			stmt := fmt.Sprintf(
+				"ALTER TABLE %s ALTER COLUMN version TYPE %s USING version::%s",
+				upgraded.quotedMigrationTable(), upgraded.versionColumnType(), upgraded.versionColumnType(),
+			)
+			if _, err := db.Exec(stmt); err != nil {
+				return fmt.Errorf("could not convert the version column from %s to %s (versions in the datetime format are too large for integer): %s", dataType, columnType, err)
+			}
+			fmt.Println("== Converted the version column from", dataType, "to", upgraded.versionColumnType(), "==")
+		}
+
+		return upgradeMigrationTable(&upgraded, db)
+	})
+}
+
+// versionColumnType returns the column type ("integer" or "string") which
+// the migration table's version column actually has, along with its
+// Postgres data type.
+func versionColumnType(c *Config, db *sql.DB) (string, string, error) {
+	var dataType string
+	err := db.QueryRow(
+		`SELECT format_type(atttypid, atttypmod) FROM pg_catalog.pg_attribute WHERE attrelid = $1::regclass AND attname = 'version'`,
+		c.quotedMigrationTable(),
+	).Scan(&dataType)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case dataType == "integer" || dataType == "bigint" || dataType == "smallint":
+		return "integer", dataType, nil
+	case dataType == "text" || strings.HasPrefix(dataType, "character"):
+		return "string", dataType, nil
+	}
+
+	return dataType, dataType, nil
+}
+
+// checkVersionColumnType returns an error explaining how to fix things if
+// the migration table's version column doesn't have the configured type.
+func checkVersionColumnType(c *Config, db *sql.DB) error {
+	actual, dataType, err := versionColumnType(c, db)
+	if err != nil || actual == c.columnType() {
+		return err
+	}
+
+	return fmt.Errorf(
+		"the version column of %s is %s, but column-type is %q. Run `pgmgr db upgrade-table --column-type %s` to convert the column, or set column-type to match it",
+		c.MigrationTable, dataType, c.columnType(), c.columnType(),
+	)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"regexp"
 	"strconv"
@@ -39,11 +40,39 @@ type Config struct {
 	// filepaths
 	MigrationFolder string `json:"migration-folder"`
 
+	// MigrationFS, if set, is read for migrations instead of the disk, e.g.
+	// an embed.FS. MigrationFolder is then the folder within it.
+	MigrationFS fs.FS `json:"-"`
+
+	// the environment being migrated, e.g. "production", which decides
+	// which environment-specific migrations are applied
+	Environment string `json:"environment"`
+	// the environments which have a folder of migrations inside
+	// MigrationFolder. The folder for Environment is read too, if it exists.
+	EnvironmentFolders []string `json:"environment-folders"`
+
 	// options
 	MigrationTable  string `json:"migration-table"`
 	MigrationDriver string `json:"migration-driver"`
 	ColumnType      string `json:"column-type"`
 	Format          string
+	AllowModified   bool   `json:"allow-modified"`
+	OutOfOrder      string `json:"out-of-order"`
+
+	// default timeouts for each migration, as Postgres intervals (e.g. "5s")
+	LockTimeout      string `json:"lock-timeout"`
+	StatementTimeout string `json:"statement-timeout"`
+
+	// dry runs, which print what would be run instead of running it
+	DryRun    bool `json:"-"`
+	DryRunSQL bool `json:"-"`
+
+	// apply every migration in a run in one transaction
+	SingleTransaction bool `json:"-"`
+
+	// locking
+	NoLock              bool `json:"no-lock"`
+	AdvisoryLockTimeout int  `json:"advisory-lock-timeout"`
 
 	// deprecated -- see dump_config.go
 	DumpFile   string   `json:"dump-file"`
@@ -168,6 +197,9 @@ func (config *Config) applyDefaults() {
 	if config.MigrationDriver == "" {
 		config.MigrationDriver = "pq"
 	}
+	if config.OutOfOrder == "" {
+		config.OutOfOrder = "allow"
+	}
 	if config.SslMode == "" {
 		config.SslMode = "disable"
 	}
@@ -199,6 +231,12 @@ func (config *Config) applyArguments(ctx argumentContext) {
 	if ctx.String("migration-folder") != "" {
 		config.MigrationFolder = ctx.String("migration-folder")
 	}
+	if ctx.String("environment") != "" {
+		config.Environment = ctx.String("environment")
+	}
+	if len(ctx.StringSlice("environment-folders")) > 0 {
+		config.EnvironmentFolders = ctx.StringSlice("environment-folders")
+	}
 	if ctx.String("migration-driver") != "" {
 		config.MigrationDriver = ctx.String("migration-driver")
 	}
@@ -211,6 +249,21 @@ func (config *Config) applyArguments(ctx argumentContext) {
 	if ctx.String("format") != "" {
 		config.Format = ctx.String("format")
 	}
+	if ctx.String("out-of-order") != "" {
+		config.OutOfOrder = ctx.String("out-of-order")
+	}
+	if ctx.String("lock-timeout") != "" {
+		config.LockTimeout = ctx.String("lock-timeout")
+	}
+	if ctx.String("statement-timeout") != "" {
+		config.StatementTimeout = ctx.String("statement-timeout")
+	}
+	if ctx.Bool("no-lock") {
+		config.NoLock = true
+	}
+	if ctx.Int("advisory-lock-timeout") != 0 {
+		config.AdvisoryLockTimeout = ctx.Int("advisory-lock-timeout")
+	}
 	config.DumpConfig.applyArguments(ctx)
 }
 
@@ -264,6 +317,19 @@ func (config *Config) validate() error {
 		return errors.New("MigrationDriver must be one of: pq, psql")
 	}
 
+	if config.OutOfOrder != "allow" && config.OutOfOrder != "warn" && config.OutOfOrder != "error" {
+		return errors.New("OutOfOrder must be one of: allow, warn, error")
+	}
+
+	if config.AdvisoryLockTimeout < 0 {
+		return errors.New("AdvisoryLockTimeout must not be negative")
+	}
+
+	// existing migrations in another format still work, so only warn
+	if err := validateMigrationFormat(config); err != nil {
+		fmt.Fprintln(os.Stderr, "WARN:", err)
+	}
+
 	return nil
 }
 
@@ -276,8 +342,18 @@ func (config *Config) quotedMigrationTable() string {
 	return pq.QuoteIdentifier(tokens[0]) + "." + pq.QuoteIdentifier(tokens[1])
 }
 
-func (config *Config) versionColumnType() string {
+// columnType returns the configured ColumnType, which is "integer" unless it
+// is "string", so that configs not built by LoadConfig work too.
+func (config *Config) columnType() string {
 	if config.ColumnType == "string" {
+		return "string"
+	}
+
+	return "integer"
+}
+
+func (config *Config) versionColumnType() string {
+	if config.columnType() == "string" {
 		return "CHARACTER VARYING (255)"
 	}
 
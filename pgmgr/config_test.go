@@ -62,6 +62,10 @@ func TestDefaults(t *testing.T) {
 	if c.SslMode != "disable" {
 		t.Fatal("config's sslmode should default to 'disable', but was ", c.SslMode)
 	}
+
+	if c.OutOfOrder != "allow" {
+		t.Fatal("config's out-of-order should default to 'allow', but was ", c.OutOfOrder)
+	}
 }
 
 func TestOverlays(t *testing.T) {
@@ -140,6 +144,31 @@ func TestOverlays(t *testing.T) {
 	}
 }
 
+func TestLockArguments(t *testing.T) {
+	c := &Config{}
+	ctx := &TestContext{
+		IntVals:  map[string]int{"advisory-lock-timeout": 30},
+		BoolVals: map[string]bool{"no-lock": true},
+	}
+
+	if err := LoadConfig(c, ctx); err != nil {
+		t.Fatal("LoadConfig failed:", err)
+	}
+
+	if c.AdvisoryLockTimeout != 30 {
+		t.Fatal("config's advisory lock timeout should come from the context, but was", c.AdvisoryLockTimeout)
+	}
+
+	if !c.NoLock {
+		t.Fatal("config's no-lock should come from the context")
+	}
+
+	c = &Config{AdvisoryLockTimeout: -1}
+	if err := LoadConfig(c, &TestContext{}); err == nil {
+		t.Fatal("LoadConfig should reject a negative AdvisoryLockTimeout")
+	}
+}
+
 func TestURL(t *testing.T) {
 	c := &Config{}
 	c.URL = "postgres://foo@bar:5431/test-db.one?sslmode=verify-ca"
@@ -185,6 +214,13 @@ func TestValidation(t *testing.T) {
 	if err := LoadConfig(c, &TestContext{}); err == nil {
 		t.Fatal("LoadConfig should prevent Format=datetime when ColumnType=integer")
 	}
+
+	c.Format = ""
+	c.ColumnType = ""
+	c.OutOfOrder = "sometimes"
+	if err := LoadConfig(c, &TestContext{}); err == nil {
+		t.Fatal("LoadConfig should reject invalid OutOfOrder value")
+	}
 }
 
 func TestQuotedMigrationTable(t *testing.T) {
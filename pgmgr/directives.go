@@ -0,0 +1,91 @@
+package pgmgr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the prefix of a comment which passes an option to pgmgr
+const directivePrefix = "-- pgmgr:"
+
+// readDirectives returns the options given by `-- pgmgr:name=value` comments
+// in the leading comment block of a migration, keyed by name. Underscores in
+// names are read as dashes, so lock_timeout and lock-timeout are the same
+// directive. A directive without a value maps to the empty string.
+func readDirectives(contents []byte) map[string]string {
+	directives := map[string]string{}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+
+		name, value, _ := strings.Cut(strings.TrimPrefix(line, directivePrefix), "=")
+		name = strings.ReplaceAll(strings.TrimSpace(name), "_", "-")
+		directives[name] = strings.TrimSpace(value)
+	}
+
+	return directives
+}
+
+// applyDirectives sets the migration's options from its directives. Unknown
+// directives are ignored, so that migrations can carry directives meant for
+// newer versions of pgmgr.
+func (m *Migration) applyDirectives(directives map[string]string) error {
+	for name, value := range directives {
+		switch name {
+		case "no-transaction":
+			noTransaction, err := parseFlagDirective(value)
+			if err != nil {
+				return fmt.Errorf("invalid no-transaction directive %q", value)
+			}
+			m.NoTransaction = noTransaction
+		case "lock-timeout":
+			m.LockTimeout = value
+		case "statement-timeout", "timeout":
+			m.StatementTimeout = value
+		case "environments":
+			m.Environments = splitDirectiveList(value)
+		case "requires":
+			for _, v := range splitDirectiveList(value) {
+				version, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid version %q in requires directive", v)
+				}
+				m.Requires = append(m.Requires, version)
+			}
+		case "description":
+			m.Description = value
+		}
+	}
+
+	return nil
+}
+
+// parseFlagDirective reads a directive which is on if given without a value.
+func parseFlagDirective(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// splitDirectiveList splits a comma-separated directive value, or returns
+// nil if it is empty.
+func splitDirectiveList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
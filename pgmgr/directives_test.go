@@ -0,0 +1,81 @@
+package pgmgr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDirectives(t *testing.T) {
+	contents := []byte(`
+-- A plain comment.
+-- pgmgr:squashed=1,2,3
+--pgmgr:ignored=because there is no space
+-- pgmgr:flag
+-- pgmgr:lock_timeout = 5s
+
+CREATE TABLE foos (foo_id INTEGER);
+-- pgmgr:too_late=true
+`)
+
+	expected := map[string]string{"squashed": "1,2,3", "flag": "", "lock-timeout": "5s"}
+	if actual := readDirectives(contents); !reflect.DeepEqual(actual, expected) {
+		t.Fatal("expected directives", expected, "got", actual)
+	}
+}
+
+func TestApplyDirectives(t *testing.T) {
+	m := Migration{Filename: "003_add_grants.up.sql", Version: 3}
+	err := m.applyDirectives(readDirectives([]byte(`-- pgmgr:no-transaction
+-- pgmgr:timeout=10min
+-- pgmgr:lock_timeout=5s
+-- pgmgr:environments=staging, production
+-- pgmgr:requires=1,2
+-- pgmgr:description=Grants read access to the reporting role
+GRANT SELECT ON foos TO reporting;
+`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Migration{
+		Filename:         "003_add_grants.up.sql",
+		Version:          3,
+		NoTransaction:    true,
+		LockTimeout:      "5s",
+		StatementTimeout: "10min",
+		Environments:     []string{"staging", "production"},
+		Requires:         []int64{1, 2},
+		Description:      "Grants read access to the reporting role",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, m)
+	}
+	if m.WrapInTransaction() {
+		t.Fatal("expected no-transaction directive to disable the transaction")
+	}
+
+	m = Migration{Filename: "004_bad.up.sql"}
+	err = m.applyDirectives(map[string]string{"requires": "1,two"})
+	if err == nil || !strings.Contains(err.Error(), "two") {
+		t.Fatal("expected an error for an invalid requires directive, got", err)
+	}
+}
+
+func TestCheckRequirements(t *testing.T) {
+	a := Migration{Filename: "001_a.up.sql", Version: 1}
+	b := Migration{Filename: "002_b.up.sql", Version: 2, Requires: []int64{1}}
+
+	if err := checkRequirements([]plannedStep{{Migration: a, Direction: UP}, {Migration: b, Direction: UP}}, nil); err != nil {
+		t.Fatal("expected a requirement applied earlier in the plan to be met, got", err)
+	}
+
+	if err := checkRequirements([]plannedStep{{Migration: b, Direction: UP}}, []int64{1}); err != nil {
+		t.Fatal("expected an applied requirement to be met, got", err)
+	}
+
+	err := checkRequirements([]plannedStep{{Migration: a, Direction: DOWN}, {Migration: b, Direction: UP}}, []int64{1})
+	if err == nil || !strings.Contains(err.Error(), "002_b.up.sql requires version 1") {
+		t.Fatal("expected an error for a requirement rolled back by the plan, got", err)
+	}
+}
@@ -0,0 +1,106 @@
+package pgmgr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// printPlan describes the given steps without running them. If DryRunSQL is
+// set, the SQL for each step is printed as well, as a psql script.
+func printPlan(c *Config, steps []plannedStep) error {
+	// in a single transaction, the steps share one BEGIN and COMMIT
+	sqlFor := stepSQL
+	if c.SingleTransaction {
+		sqlFor = stepBodySQL
+	}
+
+	if c.SingleTransaction && c.DryRunSQL {
+		fmt.Println("BEGIN;")
+	}
+
+	for _, step := range steps {
+		action := "apply"
+		if step.Direction == DOWN {
+			action = "revert"
+		}
+
+		txn := "in a transaction"
+		if c.SingleTransaction {
+			txn = "in a single transaction with the others"
+		} else if !step.Migration.WrapInTransaction() {
+			txn = "without a transaction"
+		}
+
+		if !c.DryRunSQL {
+			fmt.Printf("== Would %s %s (%s) ==\n", action, step.Migration.Filename, txn)
+			continue
+		}
+
+		if step.Migration.isGo() {
+			fmt.Printf("-- == Would %s %s (%s) ==\n-- (Go code, which has no SQL to show)\n\n", action, step.Migration.Filename, txn)
+			continue
+		}
+
+		script, err := sqlFor(c, step)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("-- == Would %s %s (%s) ==\n%s\n", action, step.Migration.Filename, txn, script)
+	}
+
+	if c.SingleTransaction && c.DryRunSQL {
+		fmt.Println("COMMIT;")
+	}
+
+	return nil
+}
+
+// stepSQL returns the SQL run for a single step, including the statements
+// which record it in the migration table, as a script runnable by psql.
+func stepSQL(c *Config, step plannedStep) (string, error) {
+	body, err := stepBodySQL(c, step)
+	if err != nil || !step.Migration.WrapInTransaction() {
+		return body, err
+	}
+
+	return "BEGIN;\n" + body + "COMMIT;\n", nil
+}
+
+// stepBodySQL is stepSQL without the transaction around it.
+func stepBodySQL(c *Config, step plannedStep) (string, error) {
+	if step.Migration.isGo() {
+		return "", fmt.Errorf("%s is a Go migration, so cannot be run by psql", step.Migration.Filename)
+	}
+
+	contents, err := readMigration(c, step.Migration)
+	if err != nil {
+		return "", err
+	}
+
+	setTimeouts, resetTimeouts := timeoutSQL(c, step.Migration)
+
+	var b strings.Builder
+	if setTimeouts != "" {
+		b.WriteString(setTimeouts + "\n")
+	}
+
+	if step.Direction == UP {
+		b.WriteString(startTimerSQL + ";\n")
+	}
+
+	b.Write(contents)
+	b.WriteString("\n;\n")
+
+	if step.Direction == UP {
+		b.WriteString(insertVersionSQL(c, step.Migration.Version, checksum(contents)) + "\n")
+	} else {
+		b.WriteString(deleteVersionSQL(c, step.Migration.Version) + "\n")
+	}
+
+	if resetTimeouts != "" {
+		b.WriteString(resetTimeouts + "\n")
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,50 @@
+package pgmgr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStepSQL(t *testing.T) {
+	clearMigrationFolder(t)
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER)`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos`)
+	writeMigration(t, "002_index_foos.no_txn.up.sql", `CREATE INDEX CONCURRENTLY ON foos (foo_id)`)
+
+	c := globalConfig()
+
+	up, err := stepSQL(c, plannedStep{Migration: Migration{Filename: "001_create_foos.up.sql", Version: 1}, Direction: UP})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"BEGIN;\n",
+		"CREATE TABLE foos (foo_id INTEGER)\n;\n",
+		`INSERT INTO "schema_migrations" (version, duration_ms, checksum, pgmgr_version) VALUES ('1', `,
+		checksum([]byte(`CREATE TABLE foos (foo_id INTEGER)`)),
+		"COMMIT;\n",
+	} {
+		if !strings.Contains(up, expected) {
+			t.Fatalf("expected SQL to contain %q, got:\n%s", expected, up)
+		}
+	}
+
+	down, err := stepSQL(c, plannedStep{Migration: Migration{Filename: "001_create_foos.down.sql", Version: 1}, Direction: DOWN})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(down, `DELETE FROM "schema_migrations" WHERE version = '1';`) {
+		t.Fatal("expected rollback SQL to delete the version, got:\n", down)
+	}
+
+	noTxn, err := stepSQL(c, plannedStep{Migration: Migration{Filename: "002_index_foos.no_txn.up.sql", Version: 2}, Direction: UP})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(noTxn, "BEGIN;") || strings.Contains(noTxn, "COMMIT;") {
+		t.Fatal("expected no transaction for a .no_txn. migration, got:\n", noTxn)
+	}
+}
@@ -0,0 +1,57 @@
+package pgmgr
+
+// environmentFolders returns the subfolders of MigrationFolder which hold
+// migrations for a single environment, e.g. migrations/production/. Only the
+// folders named by EnvironmentFolders, or after Environment, are; any other
+// subfolder is left alone, as is the folder squashed migrations are archived
+// to.
+func environmentFolders(c *Config) ([]string, error) {
+	entries, err := readMigrationDir(c, "")
+	if err != nil {
+		return nil, err
+	}
+
+	named := map[string]bool{c.Environment: c.Environment != ""}
+	for _, env := range c.EnvironmentFolders {
+		named[env] = true
+	}
+
+	folders := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && named[entry.Name()] && entry.Name() != archiveFolderName {
+			folders = append(folders, entry.Name())
+		}
+	}
+
+	return folders, nil
+}
+
+// AppliesTo returns whether the migration is for the given environment.
+// Migrations which don't name any environments are for all of them, while
+// those which do are skipped if no environment is configured.
+func (m Migration) AppliesTo(environment string) bool {
+	if len(m.Environments) == 0 {
+		return true
+	}
+
+	for _, e := range m.Environments {
+		if e == environment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forEnvironment returns the migrations which are for the configured
+// environment.
+func forEnvironment(c *Config, migrations []Migration) []Migration {
+	filtered := []Migration{}
+	for _, m := range migrations {
+		if m.AppliesTo(c.Environment) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,62 @@
+package pgmgr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAppliesTo(t *testing.T) {
+	all := Migration{Filename: "001_create_foos.up.sql"}
+	prod := Migration{Filename: "002_grant_foos.up.sql", Environments: []string{"staging", "production"}}
+
+	if !all.AppliesTo("") || !all.AppliesTo("development") {
+		t.Fatal("expected a migration without environments to apply to all of them")
+	}
+	if !prod.AppliesTo("production") || !prod.AppliesTo("staging") {
+		t.Fatal("expected a migration to apply to the environments it names")
+	}
+	if prod.AppliesTo("development") || prod.AppliesTo("") {
+		t.Fatal("expected a migration not to apply to other environments, or to none")
+	}
+}
+
+func TestMigrationsInEnvironmentFolders(t *testing.T) {
+	clearMigrationFolder(t)
+
+	for _, folder := range []string{"development", "scripts", archiveFolderName} {
+		if err := os.Mkdir(filepath.Join(migrationFolder, folder), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeMigration(t, "001_create_foos.up.sql", ``)
+	writeMigration(t, "development/002_seed_foos.up.sql", ``)
+	writeMigration(t, "003_grant_foos.up.sql", "-- pgmgr:environments=production\nGRANT SELECT ON foos TO reporting;")
+	writeMigration(t, "archive/000_old.up.sql", ``)
+	writeMigration(t, "scripts/001_not_a_migration.up.sql", ``)
+
+	// only folders named as environments hold migrations
+	c := globalConfig()
+	c.EnvironmentFolders = []string{"development", archiveFolderName}
+
+	ups, err := migrations(c, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Migration{
+		{Filename: "001_create_foos.up.sql", Version: 1},
+		{Filename: "development/002_seed_foos.up.sql", Version: 2, Environments: []string{"development"}},
+		{Filename: "003_grant_foos.up.sql", Version: 3, Environments: []string{"production"}},
+	}
+	if !reflect.DeepEqual(ups, expected) {
+		t.Fatalf("expected migrations %+v, got %+v", expected, ups)
+	}
+
+	c.Environment = "development"
+	if filtered := forEnvironment(c, ups); !reflect.DeepEqual(filtered, expected[:2]) {
+		t.Fatalf("expected development migrations %+v, got %+v", expected[:2], filtered)
+	}
+}
@@ -0,0 +1,86 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// MarkApplied records a migration as applied without running it, e.g. when
+// it was applied to the database by hand. The version must belong to an up
+// migration in MigrationFolder unless force is set.
+func MarkApplied(c *Config, version int64, force bool) error {
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return err
+	}
+
+	m, found := findVersion(ups, version)
+	if !found && !force {
+		return fmt.Errorf("no migration found with version %d", version)
+	}
+
+	return withSession(c, func(db *sql.DB) error {
+		if err := initialize(c, db); err != nil {
+			return err
+		}
+
+		applied, err := migrationIsApplied(c, db, version)
+		if err != nil {
+			return err
+		}
+		if applied {
+			return fmt.Errorf("version %d is already applied", version)
+		}
+
+		sum := ""
+		if found {
+			contents, err := readMigration(c, m)
+			if err != nil {
+				return err
+			}
+			sum = checksum(contents)
+		}
+
+		return insertSchemaVersion(c, db, version, sum, notExecuted)
+	})
+}
+
+// MarkUnapplied removes a migration from the migration table without rolling
+// it back, e.g. when it was reverted by hand. The version must belong to a
+// migration in MigrationFolder unless force is set.
+func MarkUnapplied(c *Config, version int64, force bool) error {
+	if !force {
+		ups, err := migrations(c, "up")
+		if err != nil {
+			return err
+		}
+
+		downs, err := migrations(c, "down")
+		if err != nil {
+			return err
+		}
+
+		if !containsVersion(ups, version) && !containsVersion(downs, version) {
+			return fmt.Errorf("no migration found with version %d", version)
+		}
+	}
+
+	return withSession(c, func(db *sql.DB) error {
+		exists, err := migrationTableExists(c, db)
+		if err != nil {
+			return err
+		}
+
+		applied := false
+		if exists {
+			if applied, err = migrationIsApplied(c, db, version); err != nil {
+				return err
+			}
+		}
+		if !applied {
+			return fmt.Errorf("version %d is not applied", version)
+		}
+
+		return deleteSchemaVersion(c, db, version)
+	})
+}
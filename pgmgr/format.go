@@ -0,0 +1,308 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// datetime versions are UTC timestamps, like ActiveRecord's
+const datetimeFormat = "20060102150405"
+
+var migrationFileRegex = regexp.MustCompile(`^([0-9]+)_.+\.(up|down)\.sql$`)
+
+// parseDatetimeVersion returns the time a datetime-format version stands
+// for, and whether the version is a valid datetime at all.
+func parseDatetimeVersion(version int64) (time.Time, bool) {
+	s := strconv.FormatInt(version, 10)
+	if len(s) != len(datetimeFormat) {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(datetimeFormat, s)
+	return t, err == nil
+}
+
+// legacyDatetimeFormat is the layout pgmgr used to generate datetime
+// versions before 1.1.7. It was meant to be datetimeFormat, but repeats the
+// month (unpadded) where the hour belongs, and has an unpadded 12-hour hour,
+// so those versions vary in length and lose AM/PM.
+const legacyDatetimeFormat = "20060102130405"
+
+// parseLegacyDatetimeVersion is parseDatetimeVersion for versions generated
+// with legacyDatetimeFormat. time.Parse can't read that layout back, as it
+// takes unpadded fields to be two digits long whenever it can, so the
+// version is split up by hand and must format back to itself. The hour is
+// taken to be AM, as there's no telling.
+func parseLegacyDatetimeVersion(version int64) (time.Time, bool) {
+	s := strconv.FormatInt(version, 10)
+	if len(s) < 13 || len(s) > 16 {
+		return time.Time{}, false
+	}
+
+	year, _ := strconv.Atoi(s[:4])
+	month, _ := strconv.Atoi(s[4:6])
+	day, _ := strconv.Atoi(s[6:8])
+
+	rest := strings.TrimPrefix(s[8:], strconv.Itoa(month))
+	if len(rest) != 5 && len(rest) != 6 {
+		return time.Time{}, false
+	}
+
+	hour, _ := strconv.Atoi(rest[:len(rest)-4])
+	minute, _ := strconv.Atoi(rest[len(rest)-4 : len(rest)-2])
+	second, _ := strconv.Atoi(rest[len(rest)-2:])
+
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC)
+	return t, hour >= 1 && hour <= 12 && t.Format(legacyDatetimeFormat) == s
+}
+
+func datetimeVersion(t time.Time) int64 {
+	version, _ := strconv.ParseInt(t.UTC().Format(datetimeFormat), 10, 64)
+	return version
+}
+
+// versionMatchesFormat reports whether a version belongs to the given format.
+// Any version which is not a valid datetime, or legacy datetime, counts as
+// unix, so that sequentially numbered migrations from other tools remain
+// usable. Legacy datetime versions match neither format.
+func versionMatchesFormat(version int64, format string) bool {
+	if _, isDatetime := parseDatetimeVersion(version); isDatetime {
+		return format == "datetime"
+	}
+
+	if _, isLegacy := parseLegacyDatetimeVersion(version); isLegacy {
+		return false
+	}
+
+	return format == "unix"
+}
+
+// convertVersion converts a version to the given format. Versions which
+// already match it are returned unchanged.
+func convertVersion(version int64, format string) int64 {
+	if versionMatchesFormat(version, format) {
+		return version
+	}
+
+	t, isDatetime := parseDatetimeVersion(version)
+	if !isDatetime {
+		if legacy, isLegacy := parseLegacyDatetimeVersion(version); isLegacy {
+			t = legacy
+		} else {
+			t = time.Unix(version, 0)
+		}
+	}
+
+	if format == "datetime" {
+		return datetimeVersion(t)
+	}
+
+	return t.Unix()
+}
+
+// validateMigrationFormat checks that every migration file in the migration
+// folder, and its environment folders, is named with a version in the
+// configured format, as are the registered Go migrations. A migration folder
+// which doesn't exist yet has nothing to check.
+func validateMigrationFormat(c *Config) error {
+	if c.MigrationFolder == "" && c.MigrationFS == nil {
+		return nil
+	}
+
+	folders, err := environmentFolders(c)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	mismatched := []string{}
+	for _, folder := range append([]string{""}, folders...) {
+		files, err := readMigrationDir(c, folder)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			match := migrationFileRegex.FindStringSubmatch(file.Name())
+			if match == nil {
+				continue
+			}
+
+			version, _ := strconv.ParseInt(match[1], 10, 64)
+			if !versionMatchesFormat(version, c.Format) {
+				mismatched = append(mismatched, filepath.Join(folder, file.Name()))
+			}
+		}
+	}
+
+	for _, m := range registeredMigrations("up") {
+		if !versionMatchesFormat(m.Version, c.Format) {
+			mismatched = append(mismatched, m.Filename)
+		}
+	}
+
+	if len(mismatched) == 0 {
+		return nil
+	}
+
+	if len(mismatched) > 5 {
+		mismatched = append(mismatched[:5], fmt.Sprintf("and %d more", len(mismatched)-5))
+	}
+
+	return fmt.Errorf(
+		"these migrations do not match the %q format: %s. Change the format option, or convert them with `pgmgr migration convert-format`",
+		c.Format, strings.Join(mismatched, ", "),
+	)
+}
+
+// ConvertFormat converts migrations to the given format ("unix" or
+// "datetime"). It rewrites the versions recorded in the migration table,
+// then renames the migration files to match. Anything already in the given
+// format is left alone, so it can be run once to rename the files and then
+// again against each other database which has applied them.
+func ConvertFormat(c *Config, format string) error {
+	if format != "unix" && format != "datetime" {
+		return errors.New(`format must be "unix" or "datetime"`)
+	}
+
+	if format == "datetime" && c.ColumnType != "string" {
+		return errors.New(`ColumnType must be "string" to store versions in the "datetime" format`)
+	}
+
+	if err := checkMigrationFolderWritable(c); err != nil {
+		return err
+	}
+
+	files := []Migration{}
+	for _, direction := range []string{"up", "down"} {
+		migrations, err := migrations(c, direction)
+		if err != nil {
+			return err
+		}
+		files = append(files, migrations...)
+	}
+
+	if err := checkConvertedVersions(files, format); err != nil {
+		return err
+	}
+
+	// Go migrations can't be renamed for the user
+	for _, m := range files {
+		if converted := convertVersion(m.Version, format); m.isGo() && converted != m.Version {
+			return fmt.Errorf("%s is a Go migration; register it with version %d instead, then convert again", m.Filename, converted)
+		}
+	}
+
+	err := withSession(c, func(db *sql.DB) error {
+		return convertAppliedVersions(c, db, format)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range files {
+		converted := convertVersion(m.Version, format)
+		if converted == m.Version {
+			continue
+		}
+
+		folder, name := filepath.Split(m.Filename)
+		oldPath := filepath.Join(c.MigrationFolder, m.Filename)
+		newPath := filepath.Join(c.MigrationFolder, folder, fmt.Sprint(converted)+strings.TrimLeft(name, "0123456789"))
+
+		contents, err := readMigration(c, m)
+		if err != nil {
+			return err
+		}
+
+		if squashed := squashedVersions(contents); squashed != nil {
+			if err := os.WriteFile(oldPath, convertSquashedDirective(contents, squashed, format), 0644); err != nil {
+				return err
+			}
+		}
+
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("refusing to overwrite existing migration %s", newPath)
+		}
+
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+		fmt.Println("Renamed", oldPath, "to", newPath)
+	}
+
+	return nil
+}
+
+// checkConvertedVersions returns an error if converting the migrations would
+// give two different versions the same new version.
+func checkConvertedVersions(files []Migration, format string) error {
+	converted := map[int64]int64{}
+	for _, m := range files {
+		v := convertVersion(m.Version, format)
+		if other, ok := converted[v]; ok && other != m.Version {
+			return fmt.Errorf("versions %d and %d would both convert to %d", other, m.Version, v)
+		}
+		converted[v] = m.Version
+	}
+
+	return nil
+}
+
+func convertAppliedVersions(c *Config, db *sql.DB, format string) error {
+	applied, err := appliedVersions(c, db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, v := range applied {
+		converted := convertVersion(v, format)
+		if converted == v {
+			continue
+		}
+
+		_, err := tx.Exec(
+			fmt.Sprintf(`UPDATE %s SET version = $1 WHERE version = $2`, c.quotedMigrationTable()),
+			typedVersion(c, converted), typedVersion(c, v),
+		)
+		if err != nil {
+			tx.Rollback() //nolint:errcheck // already returning an error
+			return err
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Println("== Converted", count, "versions in the migration table ==")
+	return nil
+}
+
+// convertSquashedDirective rewrites the versions listed in a squashed
+// baseline's directive, so that it still recognizes databases which applied
+// them.
+func convertSquashedDirective(contents []byte, squashed []int64, format string) []byte {
+	versions := make([]string, len(squashed))
+	for i, v := range squashed {
+		versions[i] = strconv.FormatInt(convertVersion(v, format), 10)
+	}
+
+	directive := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(directivePrefix+squashedDirective) + `=.*$`)
+	return directive.ReplaceAll(contents, []byte(directivePrefix+squashedDirective+"="+strings.Join(versions, ",")))
+}
@@ -0,0 +1,107 @@
+package pgmgr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatetimeVersion(t *testing.T) {
+	at := time.Date(2015, 9, 10, 16, 9, 33, 0, time.FixedZone("CDT", -5*60*60))
+	if v := datetimeVersion(at); v != 20150910210933 {
+		t.Fatal("expected a 24-hour UTC timestamp, got", v)
+	}
+}
+
+func TestVersionMatchesFormat(t *testing.T) {
+	cases := []struct {
+		version int64
+		format  string
+		matches bool
+	}{
+		{1433277961, "unix", true},
+		{1, "unix", true},
+		{20150910120933, "unix", false},
+		{20150910120933, "datetime", true},
+		{20150910250933, "datetime", false},
+		{1433277961, "datetime", false},
+	}
+
+	for _, tc := range cases {
+		if versionMatchesFormat(tc.version, tc.format) != tc.matches {
+			t.Errorf("expected versionMatchesFormat(%d, %q) to be %v", tc.version, tc.format, tc.matches)
+		}
+	}
+}
+
+func TestConvertVersion(t *testing.T) {
+	if v := convertVersion(1433277961, "datetime"); v != 20150602204601 {
+		t.Fatal("expected unix version to convert to 20150602204601, got", v)
+	}
+	if v := convertVersion(20150602204601, "unix"); v != 1433277961 {
+		t.Fatal("expected datetime version to convert to 1433277961, got", v)
+	}
+	if v := convertVersion(20150602204601, "datetime"); v != 20150602204601 {
+		t.Fatal("expected version already in the format to be unchanged, got", v)
+	}
+}
+
+func TestConvertLegacyDatetimeVersion(t *testing.T) {
+	// 4:09:33 on 10 September 2015, in the pre-1.1.7 datetime layout
+	legacy := int64(20150910940933)
+
+	if versionMatchesFormat(legacy, "unix") || versionMatchesFormat(legacy, "datetime") {
+		t.Fatal("expected a legacy datetime version to match neither format")
+	}
+	if v := convertVersion(legacy, "datetime"); v != 20150910040933 {
+		t.Fatal("expected legacy version to convert to 20150910040933, got", v)
+	}
+	if v := convertVersion(legacy, "unix"); v != 1441858173 {
+		t.Fatal("expected legacy version to convert to 1441858173, got", v)
+	}
+
+	// October, at 10 o'clock, is two digits longer
+	if v := convertVersion(20151010100933, "datetime"); v != 20151010100933 {
+		t.Fatal("expected a valid current datetime version to be left alone, got", v)
+	}
+	if v := convertVersion(2015101010110933, "datetime"); v != 20151010110933 {
+		t.Fatal("expected legacy version to convert to 20151010110933, got", v)
+	}
+}
+
+func TestValidateMigrationFormat(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{MigrationFolder: dir, Format: "unix"}
+
+	for _, name := range []string{"1433277961_a.up.sql", "1433277961_a.down.sql", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := validateMigrationFormat(c); err != nil {
+		t.Fatal("expected unix migrations to be valid, got", err)
+	}
+
+	c.Format = "datetime"
+	err := validateMigrationFormat(c)
+	if err == nil || !strings.Contains(err.Error(), "1433277961_a.up.sql") {
+		t.Fatal("expected an error listing the unix migrations, got", err)
+	}
+
+	c.MigrationFolder = filepath.Join(dir, "missing")
+	if err := validateMigrationFormat(c); err != nil {
+		t.Fatal("expected a missing migration folder to be valid, got", err)
+	}
+}
+
+func TestConvertSquashedDirective(t *testing.T) {
+	contents := []byte("-- pgmgr:squashed=1433277961,1433277962\n-- Baseline\n\nCREATE TABLE foos (foo_id INTEGER);\n")
+
+	converted := string(convertSquashedDirective(contents, squashedVersions(contents), "datetime"))
+	if !strings.HasPrefix(converted, "-- pgmgr:squashed=20150602204601,20150602204602\n-- Baseline\n") {
+		t.Fatal("expected squashed versions to be converted, got", converted)
+	}
+}
@@ -0,0 +1,106 @@
+package pgmgr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MigrationFunc is the up or down half of a migration written in Go. It runs
+// in a transaction, which is committed if it returns nil.
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+type goMigration struct {
+	name     string
+	up, down MigrationFunc
+}
+
+var (
+	goMigrationsMu sync.Mutex
+	goMigrations   = map[int64]goMigration{}
+)
+
+// RegisterMigration adds a migration written in Go, for anything plain SQL
+// can't express. It is applied and rolled back along with the migration
+// files, in version order, and recorded in the same migration table. down
+// may be nil if the migration can't be rolled back.
+//
+// Migrations are typically registered from an init function in the program
+// which embeds pgmgr. RegisterMigration panics if up is nil, or if a Go
+// migration is already registered with the version.
+func RegisterMigration(version int64, name string, up, down func(ctx context.Context, tx *sql.Tx) error) {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+
+	if up == nil {
+		panic(fmt.Sprintf("pgmgr: RegisterMigration for version %d has no up function", version))
+	}
+	if _, dup := goMigrations[version]; dup {
+		panic(fmt.Sprintf("pgmgr: RegisterMigration called twice for version %d", version))
+	}
+
+	goMigrations[version] = goMigration{name: name, up: up, down: down}
+}
+
+// registeredMigrations returns the registered Go migrations which can run in
+// the given direction ("up" or "down"). They are named like migration files,
+// but with a .go extension, so that they read the same in output.
+func registeredMigrations(direction string) []Migration {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+
+	migrations := []Migration{}
+	for version, g := range goMigrations {
+		fn := g.up
+		if direction == "down" {
+			fn = g.down
+		}
+		if fn == nil {
+			continue
+		}
+
+		migrations = append(migrations, Migration{
+			Filename: fmt.Sprintf("%d_%s.%s.go", version, g.name, direction),
+			Version:  version,
+			fn:       fn,
+		})
+	}
+
+	return migrations
+}
+
+// isGo returns whether the migration was registered with RegisterMigration,
+// rather than read from a file.
+func (m Migration) isGo() bool {
+	return m.fn != nil
+}
+
+// applyGoMigration runs a Go migration in its own transaction, whichever
+// driver is configured.
+func applyGoMigration(c *Config, db *sql.DB, m Migration, direction int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := execGoMigration(c, tx, m, direction); err != nil {
+		tx.Rollback() //nolint:errcheck // best-effort rollback on error path
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execGoMigration is execMigration for Go migrations.
+func execGoMigration(c *Config, tx *sql.Tx, m Migration, direction int) error {
+	return withTimeouts(c, tx, m, func() error {
+		t0 := time.Now()
+		if err := m.fn(context.Background(), tx); err != nil {
+			return fmt.Errorf("%s: %s", m.Filename, err)
+		}
+
+		return recordVersion(c, m.Version, direction, checksum(nil))(tx, time.Since(t0))
+	})
+}
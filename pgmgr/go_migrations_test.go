@@ -0,0 +1,64 @@
+package pgmgr
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+// registerTestMigration registers a Go migration for the duration of a test.
+func registerTestMigration(t *testing.T, version int64, name string, up, down MigrationFunc) {
+	RegisterMigration(version, name, up, down)
+	t.Cleanup(func() {
+		goMigrationsMu.Lock()
+		defer goMigrationsMu.Unlock()
+		delete(goMigrations, version)
+	})
+}
+
+func noopMigration(ctx context.Context, tx *sql.Tx) error { return nil }
+
+func TestRegisteredMigrationsInterleaved(t *testing.T) {
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", ``)
+	writeMigration(t, "003_create_bars.up.sql", ``)
+	registerTestMigration(t, 2, "backfill_foos", noopMigration, nil)
+
+	ups, err := migrations(globalConfig(), "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filenames := []string{}
+	for _, m := range ups {
+		filenames = append(filenames, m.Filename)
+	}
+	expected := []string{"001_create_foos.up.sql", "2_backfill_foos.up.go", "003_create_bars.up.sql"}
+	if !reflect.DeepEqual(filenames, expected) {
+		t.Fatalf("expected migrations %v, got %v", expected, filenames)
+	}
+	if !ups[1].isGo() || !ups[1].WrapInTransaction() {
+		t.Fatalf("expected a Go migration run in a transaction, got %+v", ups[1])
+	}
+
+	downs, err := migrations(globalConfig(), "down")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(downs) != 0 {
+		t.Fatal("expected a Go migration without a down function to have no down migration, got", downs)
+	}
+}
+
+func TestRegisterMigrationDuplicate(t *testing.T) {
+	registerTestMigration(t, 2, "backfill_foos", noopMigration, nil)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering a version twice to panic")
+		}
+	}()
+	RegisterMigration(2, "backfill_bars", noopMigration, nil)
+}
@@ -0,0 +1,63 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// how often to retry while another process holds the migration lock
+const lockPollInterval = 500 * time.Millisecond
+
+// withMigrationLock runs fn while holding a session-level advisory lock keyed
+// on the migration table, so that concurrent pgmgr processes migrating the
+// same database take turns instead of racing each other. The lock is taken on
+// the given session (see openSession), so it works regardless of the
+// migration driver.
+func withMigrationLock(c *Config, db *sql.DB, fn func() error) (retErr error) {
+	if c.NoLock {
+		return fn()
+	}
+
+	key := migrationLockKey(c)
+	deadline := time.Now().Add(time.Duration(c.AdvisoryLockTimeout) * time.Second)
+	for waiting := false; ; waiting = true {
+		var locked bool
+		if err := db.QueryRow(`SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+			return err
+		}
+
+		if locked {
+			break
+		}
+
+		if c.AdvisoryLockTimeout > 0 && time.Now().After(deadline) {
+			return fmt.Errorf(
+				"timed out after %d seconds waiting for the migration lock; is another pgmgr process migrating this database?",
+				c.AdvisoryLockTimeout,
+			)
+		}
+
+		if !waiting {
+			fmt.Println("Waiting for another pgmgr process to release the migration lock...")
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	defer func() {
+		if _, err := db.Exec(`SELECT pg_advisory_unlock($1)`, key); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
+	return fn()
+}
+
+// migrationLockKey derives the advisory lock key from the migration table
+// name, so that each migration table in a database has its own lock.
+func migrationLockKey(c *Config) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(c.quotedMigrationTable())) //nolint:errcheck // hash writes never fail
+	return int64(h.Sum64())
+}
@@ -0,0 +1,44 @@
+package pgmgr
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// readMigrationDir lists a folder, relative to MigrationFolder, from
+// MigrationFS if it is set, or else from the disk.
+func readMigrationDir(c *Config, folder string) ([]fs.DirEntry, error) {
+	if c.MigrationFS == nil {
+		return os.ReadDir(filepath.Join(c.MigrationFolder, folder))
+	}
+
+	return fs.ReadDir(c.MigrationFS, migrationFSPath(c, folder))
+}
+
+// readMigrationFile is readMigrationDir for a single file.
+func readMigrationFile(c *Config, name string) ([]byte, error) {
+	if c.MigrationFS == nil {
+		return os.ReadFile(filepath.Join(c.MigrationFolder, name))
+	}
+
+	return fs.ReadFile(c.MigrationFS, migrationFSPath(c, name))
+}
+
+// migrationFSPath returns the path within MigrationFS of a name relative to
+// MigrationFolder. fs.FS paths are always slash-separated.
+func migrationFSPath(c *Config, name string) string {
+	return path.Join(".", filepath.ToSlash(c.MigrationFolder), filepath.ToSlash(name))
+}
+
+// checkMigrationFolderWritable returns an error if migrations are read from
+// MigrationFS, which can't be written to.
+func checkMigrationFolderWritable(c *Config) error {
+	if c.MigrationFS != nil {
+		return errors.New("migrations are read from MigrationFS, which cannot be written to; use the migration folder on disk instead")
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package pgmgr
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func migrationMapFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/001_create_foos.up.sql":           {Data: []byte(`CREATE TABLE foos (foo_id INTEGER);`)},
+		"migrations/001_create_foos.down.sql":         {Data: []byte(`DROP TABLE foos;`)},
+		"migrations/development/002_seed_foos.up.sql": {Data: []byte(`INSERT INTO foos (foo_id) VALUES (1);`)},
+		"migrations/R__foo_views.sql":                 {Data: []byte(`CREATE OR REPLACE VIEW foo_ids AS SELECT foo_id FROM foos;`)},
+		"migrations/archive/000_squashed_away.up.sql": {Data: []byte(``)},
+		"other/003_not_a_migration_of_ours.up.sql":    {Data: []byte(``)},
+	}
+}
+
+func TestMigrationsFromFS(t *testing.T) {
+	c := &Config{MigrationFolder: "migrations", MigrationFS: migrationMapFS(), EnvironmentFolders: []string{"development"}}
+
+	ups, err := migrations(c, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Migration{
+		{Filename: "001_create_foos.up.sql", Version: 1},
+		{Filename: "development/002_seed_foos.up.sql", Version: 2, Environments: []string{"development"}},
+	}
+	if !reflect.DeepEqual(ups, expected) {
+		t.Fatalf("expected migrations %+v, got %+v", expected, ups)
+	}
+
+	contents, err := readMigration(c, ups[0])
+	if err != nil || string(contents) != `CREATE TABLE foos (foo_id INTEGER);` {
+		t.Fatal("expected to read the migration from the FS, got", string(contents), err)
+	}
+
+	repeatables, err := repeatableMigrations(c)
+	if err != nil || len(repeatables) != 1 {
+		t.Fatal("expected one repeatable migration, got", repeatables, err)
+	}
+}
+
+func TestCreateMigrationInFS(t *testing.T) {
+	c := &Config{MigrationFolder: "migrations", MigrationFS: migrationMapFS(), Format: "unix"}
+
+	if err := CreateMigration(c, "create_bars", false); err == nil {
+		t.Fatal("expected CreateMigration to refuse to write to MigrationFS")
+	}
+}
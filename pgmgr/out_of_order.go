@@ -0,0 +1,63 @@
+package pgmgr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// backdatedSteps returns the migrations the plan would apply which are older
+// than the latest version still applied once the plan's rollbacks are done,
+// e.g. because they were merged in from a long-lived branch.
+func backdatedSteps(steps []plannedStep, applied []int64) []Migration {
+	reverted := map[int64]bool{}
+	for _, step := range steps {
+		if step.Direction == DOWN {
+			reverted[step.Migration.Version] = true
+		}
+	}
+
+	latest := int64(-1)
+	for _, v := range applied {
+		if !reverted[v] && v > latest {
+			latest = v
+		}
+	}
+
+	backdated := []Migration{}
+	for _, step := range steps {
+		if step.Direction == UP && step.Migration.Version < latest {
+			backdated = append(backdated, step.Migration)
+		}
+	}
+
+	return backdated
+}
+
+// checkOutOfOrder applies the configured OutOfOrder policy to the migrations
+// which the plan would apply out of order. An unset policy, as in configs not
+// built by LoadConfig, is "allow".
+func checkOutOfOrder(c *Config, steps []plannedStep, applied []int64) error {
+	if c.OutOfOrder == "" || c.OutOfOrder == "allow" {
+		return nil
+	}
+
+	backdated := backdatedSteps(steps, applied)
+	if len(backdated) == 0 {
+		return nil
+	}
+
+	filenames := make([]string, len(backdated))
+	for i, m := range backdated {
+		filenames[i] = m.Filename
+	}
+
+	if c.OutOfOrder == "warn" {
+		fmt.Println("WARN: applying these migrations out of order, as they are older than the latest applied version:", strings.Join(filenames, ", "))
+		return nil
+	}
+
+	return fmt.Errorf(
+		"these migrations are older than the latest applied version, and out-of-order is set to error:\n  %s",
+		strings.Join(filenames, "\n  "),
+	)
+}
@@ -0,0 +1,42 @@
+package pgmgr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBackdatedSteps(t *testing.T) {
+	a := Migration{Filename: "001_a.up.sql", Version: 1}
+	b := Migration{Filename: "002_b.up.sql", Version: 2}
+	d := Migration{Filename: "004_d.down.sql", Version: 4}
+	e := Migration{Filename: "005_e.up.sql", Version: 5}
+
+	steps := []plannedStep{{Migration: a, Direction: UP}, {Migration: b, Direction: UP}, {Migration: e, Direction: UP}}
+	if backdated := backdatedSteps(steps, []int64{3}); !reflect.DeepEqual(backdated, []Migration{a, b}) {
+		t.Fatal("expected migrations older than version 3 to be backdated, got", backdated)
+	}
+
+	if backdated := backdatedSteps(steps, []int64{}); len(backdated) != 0 {
+		t.Fatal("expected nothing to be backdated on an empty database, got", backdated)
+	}
+
+	// rolling back version 4 first leaves version 1 as the latest applied
+	steps = []plannedStep{{Migration: d, Direction: DOWN}, {Migration: b, Direction: UP}}
+	if backdated := backdatedSteps(steps, []int64{1, 4}); len(backdated) != 0 {
+		t.Fatal("expected nothing to be backdated after rolling back, got", backdated)
+	}
+}
+
+func TestCheckOutOfOrder(t *testing.T) {
+	steps := []plannedStep{{Migration: Migration{Filename: "001_a.up.sql", Version: 1}, Direction: UP}}
+
+	for _, policy := range []string{"", "allow", "warn"} {
+		if err := checkOutOfOrder(&Config{OutOfOrder: policy}, steps, []int64{2}); err != nil {
+			t.Fatalf("expected out-of-order %q to apply backdated migrations, got %s", policy, err)
+		}
+	}
+
+	if err := checkOutOfOrder(&Config{OutOfOrder: "error"}, steps, []int64{2}); err == nil {
+		t.Fatal("expected out-of-order error to refuse backdated migrations")
+	}
+}
@@ -2,13 +2,17 @@ package pgmgr
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,7 +26,9 @@ const (
 	UP
 )
 
-const datetimeFormat = "20060102130405"
+// ToolVersion is the version of pgmgr itself, which is recorded alongside
+// each applied migration.
+const ToolVersion = "1.1.7"
 
 // Migration directions used for error message building
 const (
@@ -30,16 +36,44 @@ const (
 	ROLLBACK  = "rollback"
 )
 
-// Migration stores a single migration's version and filename.
+// plannedStep is a single migration to be run in the given direction.
+type plannedStep struct {
+	Migration Migration
+	Direction int
+}
+
+// Migration stores a single migration's version and filename, along with
+// any options given by directives in its header (see readDirectives). Go
+// migrations (see RegisterMigration) have no file, and no directives.
 type Migration struct {
 	Filename string
 	Version  int64
+
+	// NoTransaction is set by the no-transaction directive.
+	NoTransaction bool
+	// LockTimeout and StatementTimeout are set by the lock-timeout and
+	// statement-timeout (or just timeout) directives, and override the
+	// config's defaults.
+	LockTimeout      string
+	StatementTimeout string
+	// Environments lists the environments the migration is for, as given by
+	// the environments directive. It is empty if the migration is for all.
+	Environments []string
+	// Requires lists versions which must be applied before this migration,
+	// as given by the requires directive.
+	Requires []int64
+	// Description is set by the description directive.
+	Description string
+
+	// fn runs a Go migration; see RegisterMigration.
+	fn MigrationFunc
 }
 
 // WrapInTransaction returns whether the migration should be run within
-// a transaction.
+// a transaction. Besides the no-transaction directive, migrations named
+// with `.no_txn.` are also run without one.
 func (m Migration) WrapInTransaction() bool {
-	return !strings.Contains(m.Filename, ".no_txn.")
+	return !m.NoTransaction && !strings.Contains(m.Filename, ".no_txn.")
 }
 
 // Create creates the database specified by the configuration.
@@ -140,76 +174,232 @@ func Load(c *Config) error {
 
 // Migrate applies un-applied migrations in the specified MigrationFolder.
 func Migrate(c *Config) error {
-	migrations, err := migrations(c, "up")
+	return MigrateTo(c, math.MaxInt64)
+}
+
+// MigrateTo brings the database to the given version: un-applied migrations
+// up to and including that version are applied, and applied migrations newer
+// than it are rolled back, latest first.
+func MigrateTo(c *Config, version int64) error {
+	return withSession(c, func(db *sql.DB) error {
+		return migrateTo(c, db, version)
+	})
+}
+
+func migrateTo(c *Config, db *sql.DB, version int64) error {
+	ups, err := migrations(c, "up")
 	if err != nil {
 		return err
 	}
 
-	// ensure the version table is created
-	if err := Initialize(c); err != nil {
+	downs, err := migrations(c, "down")
+	if err != nil {
 		return err
 	}
 
-	appliedAny := false
-	for _, m := range migrations {
-		if applied, _ := migrationIsApplied(c, m.Version); !applied {
-			fmt.Println("== Applying", m.Filename, "==")
-			t0 := time.Now()
+	// migrations for other environments are skipped, though not their
+	// rollbacks, in case they were applied before being scoped
+	ups = forEnvironment(c, ups)
 
-			if err = applyMigration(c, m, UP); err != nil { // halt the migration process and return the error.
-				printFailedMigrationMessage(err, MIGRATION)
-				return err
-			}
+	// ensure the version table is created
+	if !c.DryRun {
+		if err := initialize(c, db); err != nil {
+			return err
+		}
+	}
+
+	applied, err := appliedVersionsInOrder(c, db)
+	if err != nil {
+		return err
+	}
+
+	applied, err = satisfySquashedBaselines(c, db, ups, applied)
+	if err != nil {
+		return err
+	}
 
-			fmt.Println("== Completed in", time.Since(t0).Nanoseconds()/1e6, "ms ==")
-			appliedAny = true
+	if !c.AllowModified {
+		mismatches, err := checksumMismatches(c, db, ups)
+		if err != nil {
+			return err
+		}
+		if len(mismatches) > 0 {
+			return modifiedMigrationsError(mismatches)
 		}
 	}
 
-	if !appliedAny {
+	steps, err := planMigrateTo(ups, downs, applied, version)
+	if err != nil {
+		return err
+	}
+
+	if err := checkOutOfOrder(c, steps, applied); err != nil {
+		return err
+	}
+
+	if err := checkRequirements(steps, applied); err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
 		fmt.Println("Nothing to do; all migrations already applied.")
+	} else if err := runSteps(c, db, steps); err != nil {
+		return err
 	}
 
-	return nil
+	// repeatable migrations come after every versioned one, so are only
+	// applied when migrating all the way up
+	if version != math.MaxInt64 {
+		return nil
+	}
+
+	return applyRepeatables(c, db)
 }
 
 // Rollback un-applies the latest migration, if possible.
 func Rollback(c *Config) error {
-	migrations, err := migrations(c, "down")
+	return RollbackSteps(c, 1)
+}
+
+// RollbackSteps un-applies the given number of migrations, most recently
+// applied first. No migrations are reverted if any of them lacks a
+// .down.sql file.
+func RollbackSteps(c *Config, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("cannot roll back %d steps; must be at least 1", steps)
+	}
+
+	return withSession(c, func(db *sql.DB) error {
+		plan, err := planRollback(c, db, latestApplied(steps))
+		if err != nil {
+			return err
+		}
+
+		return runSteps(c, db, plan)
+	})
+}
+
+// RollbackTo un-applies every migration applied after the given version,
+// most recently applied first. No migrations are reverted if any of them
+// lacks a .down.sql file.
+func RollbackTo(c *Config, version int64) error {
+	return withSession(c, func(db *sql.DB) error {
+		plan, err := planRollback(c, db, func(applied []int64) ([]int64, error) {
+			for i, v := range applied {
+				if v == version {
+					return applied[i+1:], nil
+				}
+			}
+			return nil, fmt.Errorf("cannot roll back to version %d: it has not been applied", version)
+		})
+		if err != nil {
+			return err
+		}
+
+		return runSteps(c, db, plan)
+	})
+}
+
+// Redo rolls back the given number of migrations, most recently applied
+// first, and then re-applies them. The migration files are re-read in
+// between, so any edits made to them are picked up.
+func Redo(c *Config, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("cannot redo %d steps; must be at least 1", steps)
+	}
+
+	return withSession(c, func(db *sql.DB) error {
+		return redo(c, db, steps)
+	})
+}
+
+func redo(c *Config, db *sql.DB, steps int) error {
+	// upgrade tables from older versions of pgmgr before rolling anything
+	// back, so that re-applying can record the migration details
+	if !c.DryRun {
+		if err := initialize(c, db); err != nil {
+			return err
+		}
+	}
+
+	plan, err := planRollback(c, db, latestApplied(steps))
 	if err != nil {
 		return err
 	}
 
-	v, _ := Version(c)
-	var toRollback *Migration
-	for _, m := range migrations {
-		if m.Version == v {
-			toRollback = &m
-			break
-		}
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return err
 	}
 
-	if toRollback == nil {
-		return nil
+	for _, step := range plan {
+		if !containsVersion(ups, step.Migration.Version) {
+			return fmt.Errorf("cannot redo version %d: no .up.sql migration found", step.Migration.Version)
+		}
 	}
 
-	// rollback only the last migration
-	fmt.Println("== Reverting", toRollback.Filename, "==")
-	t0 := time.Now()
+	if err := runSteps(c, db, plan); err != nil {
+		return err
+	}
 
-	if err = applyMigration(c, *toRollback, DOWN); err != nil {
-		printFailedMigrationMessage(err, ROLLBACK)
+	// re-read the migrations so edits made since they were applied are used
+	ups, err = migrations(c, "up")
+	if err != nil {
 		return err
 	}
 
-	fmt.Println("== Completed in", time.Since(t0).Nanoseconds()/1e6, "ms ==")
+	redo := []plannedStep{}
+	for i := len(plan) - 1; i >= 0; i-- {
+		m, ok := findVersion(ups, plan[i].Migration.Version)
+		if !ok {
+			return fmt.Errorf("cannot redo version %d: no .up.sql migration found", plan[i].Migration.Version)
+		}
+		redo = append(redo, plannedStep{Migration: m, Direction: UP})
+	}
 
-	return nil
+	return runSteps(c, db, redo)
+}
+
+// latestApplied chooses the given number of most recently applied versions.
+func latestApplied(steps int) func(applied []int64) ([]int64, error) {
+	return func(applied []int64) ([]int64, error) {
+		if steps > len(applied) {
+			return applied, nil
+		}
+		return applied[len(applied)-steps:], nil
+	}
+}
+
+// planRollback returns the steps needed to revert the versions chosen by the
+// given function, which receives the applied versions in the order they were
+// applied. The chosen versions are reverted most recently applied first.
+func planRollback(c *Config, db *sql.DB, choose func(applied []int64) ([]int64, error)) ([]plannedStep, error) {
+	downs, err := migrations(c, "down")
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersionsInOrder(c, db)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen, err := choose(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, 0, len(chosen))
+	for i := len(chosen) - 1; i >= 0; i-- {
+		versions = append(versions, chosen[i])
+	}
+
+	return rollbackSteps(downs, versions)
 }
 
-// Version returns the highest version number stored in the database. This is not
-// necessarily enough info to uniquely identify the version, since there may
-// be backdated migrations which have not yet applied.
+// Version returns the most recently applied version, or -1 if the migration
+// table does not exist or is empty. Since backdated migrations may be applied
+// after newer ones, this is not necessarily the highest applied version.
 func Version(c *Config) (int64, error) {
 	db, err := openConnection(c)
 	if err != nil {
@@ -226,16 +416,20 @@ func Version(c *Config) (int64, error) {
 		return -1, nil
 	}
 
-	var version int64
-	err = db.QueryRow(fmt.Sprintf(
-		`SELECT COALESCE(MAX(version)::text, '-1') FROM %s`,
-		c.quotedMigrationTable(),
-	)).Scan(&version)
+	if err := checkVersionColumnType(c, db); err != nil {
+		return -1, err
+	}
 
-	return version, err
+	applied, err := appliedVersionsInOrder(c, db)
+	if err != nil || len(applied) == 0 {
+		return -1, err
+	}
+
+	return applied[len(applied)-1], nil
 }
 
-// Initialize creates the schema_migrations table if necessary.
+// Initialize creates the schema_migrations table if necessary, and upgrades
+// tables created by older versions of pgmgr to record migration details.
 func Initialize(c *Config) error {
 	db, err := openConnection(c)
 	if err != nil {
@@ -243,6 +437,10 @@ func Initialize(c *Config) error {
 	}
 	defer db.Close() //nolint:errcheck
 
+	return initialize(c, db)
+}
+
+func initialize(c *Config, db *sql.DB) error {
 	if err := createSchemaUnlessExists(c, db); err != nil {
 		return err
 	}
@@ -252,27 +450,90 @@ func Initialize(c *Config) error {
 		return err
 	}
 
-	if tableExists {
-		return nil
+	if !tableExists {
+		_, err := db.Exec(fmt.Sprintf(
+			"CREATE TABLE %s (version %s NOT NULL UNIQUE);",
+			c.quotedMigrationTable(),
+			c.versionColumnType(),
+		))
+
+		if err != nil {
+			return err
+		}
+	} else if err := checkVersionColumnType(c, db); err != nil {
+		return err
 	}
 
-	_, err = db.Exec(fmt.Sprintf(
-		"CREATE TABLE %s (version %s NOT NULL UNIQUE);",
-		c.quotedMigrationTable(),
-		c.versionColumnType(),
-	))
+	return upgradeMigrationTable(c, db)
+}
+
+// migrationTableColumns lists the columns which record details about each
+// applied migration, beyond its version. Tables created by older versions of
+// pgmgr are upgraded in place by adding whichever of these are missing.
+var migrationTableColumns = []struct {
+	name, dataType, defaultValue string
+
+	// backfill, if set, is run after adding the column to an existing table.
+	// It is formatted with the quoted table name.
+	backfill string
+}{
+	{"applied_at", "TIMESTAMP WITH TIME ZONE", "now()", ""},
+	{"duration_ms", "BIGINT", "", ""},
+	{"checksum", "CHARACTER VARYING (64)", "", ""},
+	{"applied_by", "TEXT", "current_user", ""},
+	{"pgmgr_version", "TEXT", "", ""},
+	// the order in which migrations were applied, which rollbacks follow.
+	// Rows applied before this column existed are numbered by when they were
+	// applied, if known, and then by version.
+	{appliedOrderColumn, "BIGSERIAL", "", `UPDATE %[1]s AS m SET applied_order = o.n
+		FROM (SELECT version, row_number() OVER (ORDER BY applied_at NULLS FIRST, version) AS n FROM %[1]s) AS o
+		WHERE m.version = o.version`},
+}
+
+const appliedOrderColumn = "applied_order"
 
+func upgradeMigrationTable(c *Config, db *sql.DB) error {
+	existing, err := migrationTableColumnNames(c, db)
 	if err != nil {
 		return err
 	}
 
+	for _, col := range migrationTableColumns {
+		if existing[col.name] {
+			continue
+		}
+
+		// add the column before setting its default, so that rows which were
+		// applied before the upgrade are left NULL rather than backfilled.
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", c.quotedMigrationTable(), col.name, col.dataType)
+		if col.defaultValue != "" {
+			stmt += fmt.Sprintf(" ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", c.quotedMigrationTable(), col.name, col.defaultValue)
+		}
+
+		if col.backfill != "" {
+			stmt += " " + fmt.Sprintf(col.backfill, c.quotedMigrationTable()) + ";"
+		}
+
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // CreateMigration generates new, empty migration files.
 func CreateMigration(c *Config, name string, noTransaction bool) error {
-	version := generateVersion(c)
-	prefix := fmt.Sprint(version, "_", name)
+	if err := checkMigrationFolderWritable(c); err != nil {
+		return err
+	}
+
+	newest, err := newestVersion(c)
+	if err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprint(generateVersion(c, newest), "_", name)
 
 	if noTransaction {
 		prefix += ".no_txn"
@@ -281,13 +542,19 @@ func CreateMigration(c *Config, name string, noTransaction bool) error {
 	upFilepath := filepath.Join(c.MigrationFolder, prefix+".up.sql")
 	downFilepath := filepath.Join(c.MigrationFolder, prefix+".down.sql")
 
-	err := os.WriteFile(upFilepath, []byte(`-- Migration goes here.`), 0644)
+	for _, path := range []string{upFilepath, downFilepath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("refusing to overwrite existing migration %s", path)
+		}
+	}
+
+	err = writeNewFile(upFilepath, []byte(`-- Migration goes here.`))
 	if err != nil {
 		return err
 	}
 	fmt.Println("Created", upFilepath)
 
-	err = os.WriteFile(downFilepath, []byte(`-- Rollback of migration goes here. If you don't want to write it, delete this file.`), 0644)
+	err = writeNewFile(downFilepath, []byte(`-- Rollback of migration goes here. If you don't want to write it, delete this file.`))
 	if err != nil {
 		return err
 	}
@@ -296,15 +563,62 @@ func CreateMigration(c *Config, name string, noTransaction bool) error {
 	return nil
 }
 
-func generateVersion(c *Config) string {
-	// TODO: guarantee no conflicts by incrementing if there is a conflict
+// writeNewFile is os.WriteFile, except that it fails rather than truncating
+// a file which already exists.
+func writeNewFile(path string, contents []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// newestVersion returns the highest version of any up or down migration in
+// the migration folder, or 0 if there are none.
+func newestVersion(c *Config) (int64, error) {
+	var newest int64
+	for _, direction := range []string{"up", "down"} {
+		migrations, err := migrations(c, direction)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, m := range migrations {
+			if m.Version > newest {
+				newest = m.Version
+			}
+		}
+	}
+
+	return newest, nil
+}
+
+// generateVersion returns a version for a new migration based on the current
+// time, bumped as needed so that it is strictly greater than newest.
+func generateVersion(c *Config, newest int64) int64 {
 	t := time.Now()
 
 	if c.Format == "datetime" {
-		return t.Format(datetimeFormat)
+		if last, ok := parseDatetimeVersion(newest); ok && !t.After(last) {
+			t = last.Add(time.Second)
+		}
+
+		if version := datetimeVersion(t); version > newest {
+			return version
+		}
+		return newest + 1
 	}
 
-	return strconv.FormatInt(t.Unix(), 10)
+	if t.Unix() > newest {
+		return t.Unix()
+	}
+	return newest + 1
 }
 
 // need access to the original query contents in order to print it out properly,
@@ -321,21 +635,179 @@ type execer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
-func applyMigration(c *Config, m Migration, direction int) error {
+// planMigrateTo returns the steps needed to bring a database with the given
+// applied versions, in the order they were applied, to the target version.
+// Applied migrations newer than the target are rolled back first, most
+// recently applied first, then any un-applied migrations up to and including
+// the target are applied in file order.
+func planMigrateTo(ups, downs []Migration, applied []int64, target int64) ([]plannedStep, error) {
+	isApplied := map[int64]bool{}
+	for _, v := range applied {
+		isApplied[v] = true
+	}
+
+	if target != math.MaxInt64 && target != -1 && !isApplied[target] && !containsVersion(ups, target) {
+		return nil, fmt.Errorf("no migration found with version %d", target)
+	}
+
+	toRollback := []int64{}
+	for i := len(applied) - 1; i >= 0; i-- {
+		if applied[i] > target {
+			toRollback = append(toRollback, applied[i])
+		}
+	}
+
+	steps, err := rollbackSteps(downs, toRollback)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range ups {
+		if m.Version <= target && !isApplied[m.Version] {
+			steps = append(steps, plannedStep{Migration: m, Direction: UP})
+		}
+	}
+
+	return steps, nil
+}
+
+// checkRequirements returns an error if the plan would apply a migration
+// before the versions it requires (see Migration.Requires) are applied.
+func checkRequirements(steps []plannedStep, applied []int64) error {
+	isApplied := map[int64]bool{}
+	for _, v := range applied {
+		isApplied[v] = true
+	}
+
+	for _, step := range steps {
+		if step.Direction == DOWN {
+			isApplied[step.Migration.Version] = false
+			continue
+		}
+
+		for _, required := range step.Migration.Requires {
+			if !isApplied[required] {
+				return fmt.Errorf("%s requires version %d, which would not be applied before it", step.Migration.Filename, required)
+			}
+		}
+		isApplied[step.Migration.Version] = true
+	}
+
+	return nil
+}
+
+// rollbackSteps returns a DOWN step for each of the given versions, in the
+// order given, or an error if any of them has no down migration.
+func rollbackSteps(downs []Migration, versions []int64) ([]plannedStep, error) {
+	steps := []plannedStep{}
+	for _, v := range versions {
+		m, ok := findVersion(downs, v)
+		if !ok {
+			return nil, fmt.Errorf("cannot roll back version %d: no .down.sql migration found", v)
+		}
+		steps = append(steps, plannedStep{Migration: m, Direction: DOWN})
+	}
+	return steps, nil
+}
+
+// runSteps applies each step in order, halting at the first failure. On a
+// dry run, the steps are printed instead.
+func runSteps(c *Config, db *sql.DB, steps []plannedStep) error {
+	if c.SingleTransaction {
+		if err := checkSingleTransaction(steps); err != nil {
+			return err
+		}
+	}
+
+	if c.DryRun {
+		return printPlan(c, steps)
+	}
+
+	if c.SingleTransaction {
+		return runStepsInSingleTransaction(c, db, steps)
+	}
+
+	for _, step := range steps {
+		migrationType := MIGRATION
+		if step.Direction == UP {
+			fmt.Println("== Applying", step.Migration.Filename, "==")
+		} else {
+			fmt.Println("== Reverting", step.Migration.Filename, "==")
+			migrationType = ROLLBACK
+		}
+		t0 := time.Now()
+
+		if err := applyMigration(c, db, step.Migration, step.Direction); err != nil { // halt the process and return the error.
+			printFailedMigrationMessage(err, migrationType)
+			return err
+		}
+
+		fmt.Println("== Completed in", time.Since(t0).Nanoseconds()/1e6, "ms ==")
+	}
+
+	return nil
+}
+
+func sortVersionsDescending(versions []int64) {
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+}
+
+func containsVersion(migrations []Migration, version int64) bool {
+	_, ok := findVersion(migrations, version)
+	return ok
+}
+
+func findVersion(migrations []Migration, version int64) (Migration, bool) {
+	for _, m := range migrations {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// applyMigration runs a single migration. The pq driver runs it on the given
+// session; the psql driver starts its own.
+func applyMigration(c *Config, db *sql.DB, m Migration, direction int) error {
+	if m.isGo() {
+		return applyGoMigration(c, db, m, direction)
+	}
+
 	if c.MigrationDriver == "psql" {
 		return applyMigrationByPsql(c, m, direction)
 	}
 
-	return applyMigrationByPq(c, m, direction)
+	return applyMigrationByPq(c, db, m, direction)
+}
+
+// readMigration returns the contents of a migration file. Go migrations
+// have none.
+func readMigration(c *Config, m Migration) ([]byte, error) {
+	if m.isGo() {
+		return nil, nil
+	}
+
+	return readMigrationFile(c, m.Filename)
 }
 
 func applyMigrationByPsql(c *Config, m Migration, direction int) error {
-	if err := c.DumpToEnv(); err != nil {
+	contents, err := readMigration(c, m)
+	if err != nil {
 		return err
 	}
 
-	contents, err := os.ReadFile(filepath.Join(c.MigrationFolder, m.Filename))
-	if err != nil {
+	record := deleteVersionSQL(c, m.Version)
+	if direction == UP {
+		record = insertVersionSQL(c, m.Version, checksum(contents))
+	}
+
+	return runByPsql(c, m, contents, record)
+}
+
+// runByPsql runs a migration's contents through psql, followed by the given
+// statement to record it.
+func runByPsql(c *Config, m Migration, contents []byte, record string) error {
+	if err := c.DumpToEnv(); err != nil {
 		return err
 	}
 
@@ -350,22 +822,23 @@ func applyMigrationByPsql(c *Config, m Migration, direction int) error {
 		return err
 	}
 
-	var wsErr error
-	if direction == UP {
-		_, wsErr = fmt.Fprintf(tmpfile, "\n; INSERT INTO %s (version) VALUES ('%d');", c.quotedMigrationTable(), m.Version)
-	} else { // DOWN
-		_, wsErr = fmt.Fprintf(tmpfile, "\n; DELETE FROM %s WHERE version = '%d';", c.quotedMigrationTable(), m.Version)
-	}
-	if wsErr != nil {
-		return wsErr
+	if _, err := fmt.Fprintf(tmpfile, "\n; %s", record); err != nil {
+		return err
 	}
 
 	if err := tmpfile.Close(); err != nil {
 		return err
 	}
 
+	// the timer is started by a separate command, rather than by a line in
+	// the temp file, so that psql's error line numbers match the migration.
 	migrationFilePath := tmpfile.Name()
-	args := []string{"-f", migrationFilePath, "-v", "ON_ERROR_STOP=1"}
+	args := []string{"-c", startTimerSQL, "-f", migrationFilePath, "-v", "ON_ERROR_STOP=1"}
+
+	// each migration gets its own psql session, so timeouts needn't be reset
+	if setTimeouts, _ := timeoutSQL(c, m); setTimeouts != "" {
+		args = append([]string{"-c", setTimeouts}, args...)
+	}
 
 	if m.WrapInTransaction() {
 		args = append(args, "-1")
@@ -378,56 +851,83 @@ func applyMigrationByPsql(c *Config, m Migration, direction int) error {
 	return nil
 }
 
-func applyMigrationByPq(c *Config, m Migration, direction int) error {
-	var tx *sql.Tx
-	var exec execer
+func applyMigrationByPq(c *Config, db *sql.DB, m Migration, direction int) error {
+	contents, err := readMigration(c, m)
+	if err != nil {
+		return err
+	}
 
-	rollback := func() {
-		if tx != nil {
-			tx.Rollback() //nolint:errcheck // best-effort rollback on error path
-		}
+	return inMigrationTransaction(db, m, func(exec execer) error {
+		return execMigration(c, exec, m, contents, recordVersion(c, m.Version, direction, checksum(contents)))
+	})
+}
+
+// inMigrationTransaction runs fn in a transaction, unless the migration
+// isn't to be run in one.
+func inMigrationTransaction(db *sql.DB, m Migration, fn func(exec execer) error) error {
+	if !m.WrapInTransaction() {
+		return fn(db)
 	}
 
-	contents, err := os.ReadFile(filepath.Join(c.MigrationFolder, m.Filename))
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	db, err := openConnection(c)
-	if err != nil {
+	if err := fn(tx); err != nil {
+		tx.Rollback() //nolint:errcheck // best-effort rollback on error path
 		return err
 	}
-	defer db.Close() //nolint:errcheck
-	exec = db
 
-	if m.WrapInTransaction() {
-		tx, err = db.Begin()
-		if err != nil {
-			return err
-		}
-		exec = tx
-	}
+	return tx.Commit()
+}
 
-	if _, err = exec.Exec(string(contents)); err != nil {
-		rollback()
-		return errors.New(formatPgErr(&contents, err.(*pq.Error)))
+// recordFunc records that a migration was run, given how long it took.
+type recordFunc func(exec execer, duration time.Duration) error
+
+// recordVersion returns the recordFunc which adds a version to, or removes
+// it from, the migration table.
+func recordVersion(c *Config, version int64, direction int, checksum string) recordFunc {
+	return func(exec execer, duration time.Duration) error {
+		if direction == UP {
+			return insertSchemaVersion(c, exec, version, checksum, duration)
+		}
+		return deleteSchemaVersion(c, exec, version)
 	}
+}
 
-	if direction == UP {
-		if err = insertSchemaVersion(c, exec, m.Version); err != nil {
-			rollback()
+// execMigration runs a migration's contents and then records it, leaving any
+// transaction handling to the caller.
+func execMigration(c *Config, exec execer, m Migration, contents []byte, record recordFunc) error {
+	return withTimeouts(c, exec, m, func() error {
+		t0 := time.Now()
+		if _, err := exec.Exec(string(contents)); err != nil {
 			return errors.New(formatPgErr(&contents, err.(*pq.Error)))
 		}
-	} else {
-		if err = deleteSchemaVersion(c, exec, m.Version); err != nil {
-			rollback()
+
+		if err := record(exec, time.Since(t0)); err != nil {
 			return errors.New(formatPgErr(&contents, err.(*pq.Error)))
 		}
+
+		return nil
+	})
+}
+
+// withTimeouts runs fn with the migration's timeouts set (see timeoutSQL).
+func withTimeouts(c *Config, exec execer, m Migration, fn func() error) error {
+	setTimeouts, resetTimeouts := timeoutSQL(c, m)
+	if setTimeouts != "" {
+		if _, err := exec.Exec(setTimeouts); err != nil {
+			return err
+		}
 	}
 
-	if tx != nil {
-		err = tx.Commit()
-		if err != nil {
+	if err := fn(); err != nil {
+		return err
+	}
+
+	if resetTimeouts != "" {
+		if _, err := exec.Exec(resetTimeouts); err != nil {
 			return err
 		}
 	}
@@ -464,14 +964,60 @@ func createSchemaUnlessExists(c *Config, db *sql.DB) error {
 	return err
 }
 
-func insertSchemaVersion(c *Config, tx execer, version int64) error {
+// notExecuted is the duration recorded for a version which was marked applied
+// without running its migration, and is stored as NULL.
+const notExecuted time.Duration = -1
+
+func insertSchemaVersion(c *Config, tx execer, version int64, checksum string, duration time.Duration) error {
 	_, err := tx.Exec(
-		fmt.Sprintf(`INSERT INTO %s (version) VALUES ($1) RETURNING version;`, c.quotedMigrationTable()),
+		fmt.Sprintf(
+			`INSERT INTO %s (version, duration_ms, checksum, pgmgr_version) VALUES ($1, $2, $3, $4) RETURNING version;`,
+			c.quotedMigrationTable(),
+		),
 		typedVersion(c, version),
+		sql.NullInt64{Int64: duration.Milliseconds(), Valid: duration != notExecuted},
+		sql.NullString{String: checksum, Valid: checksum != ""},
+		ToolVersion,
 	)
 	return err
 }
 
+// startTimerSQL records the current time in the session, so that a later
+// insertVersionSQL in the same psql session can compute the duration.
+const startTimerSQL = `DO $$ BEGIN PERFORM set_config('pgmgr.started_at', clock_timestamp()::text, false); END $$`
+
+// insertVersionSQL returns a self-contained equivalent of insertSchemaVersion,
+// for use in scripts run through psql.
+func insertVersionSQL(c *Config, version int64, checksum string) string {
+	return insertVersionWithDurationSQL(c, version, checksum,
+		`(EXTRACT(EPOCH FROM clock_timestamp() - current_setting('pgmgr.started_at', true)::timestamptz) * 1000)::bigint`)
+}
+
+// markVersionSQL is insertVersionSQL for a version which is marked applied
+// without running its migration, so has no duration.
+func markVersionSQL(c *Config, version int64, checksum string) string {
+	return insertVersionWithDurationSQL(c, version, checksum, "NULL")
+}
+
+func insertVersionWithDurationSQL(c *Config, version int64, checksum, duration string) string {
+	return fmt.Sprintf(
+		`INSERT INTO %s (version, duration_ms, checksum, pgmgr_version) VALUES ('%d', %s, '%s', '%s');`,
+		c.quotedMigrationTable(), version, duration, checksum, ToolVersion,
+	)
+}
+
+// deleteVersionSQL returns a self-contained equivalent of deleteSchemaVersion,
+// for use in scripts run through psql.
+func deleteVersionSQL(c *Config, version int64) string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE version = '%d';`, c.quotedMigrationTable(), version)
+}
+
+// checksum returns the hex-encoded SHA-256 of a migration's contents.
+func checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
 func deleteSchemaVersion(c *Config, tx execer, version int64) error {
 	_, err := tx.Exec(
 		fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, c.quotedMigrationTable()),
@@ -481,7 +1027,7 @@ func deleteSchemaVersion(c *Config, tx execer, version int64) error {
 }
 
 func typedVersion(c *Config, version int64) interface{} {
-	if c.ColumnType == "string" {
+	if c.columnType() == "string" {
 		return strconv.FormatInt(version, 10)
 	}
 	return version
@@ -507,17 +1053,38 @@ func migrationTableExists(c *Config, db *sql.DB) (bool, error) {
 	return hasTable, err
 }
 
-func migrationIsApplied(c *Config, version int64) (bool, error) {
-	db, err := openConnection(c)
+// migrationTableColumnNames returns the set of columns in the migration table.
+func migrationTableColumnNames(c *Config, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(
+		`SELECT attname FROM pg_catalog.pg_attribute WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped`,
+		c.quotedMigrationTable(),
+	)
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+func migrationIsApplied(c *Config, db *sql.DB, version int64) (bool, error) {
+	if err := checkVersionColumnType(c, db); err != nil {
 		return false, err
 	}
-	defer db.Close() //nolint:errcheck
 
 	var applied bool
-	err = db.QueryRow(
+	err := db.QueryRow(
 		fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE version = $1)`, c.quotedMigrationTable()),
-		version,
+		typedVersion(c, version),
 	).Scan(&applied)
 
 	if err != nil {
@@ -527,6 +1094,110 @@ func migrationIsApplied(c *Config, version int64) (bool, error) {
 	return applied, nil
 }
 
+// appliedVersions returns every version recorded in the migration table, or
+// none if the table has not been created yet.
+func appliedVersions(c *Config, db *sql.DB) ([]int64, error) {
+	versions := []int64{}
+
+	exists, err := migrationTableExists(c, db)
+	if err != nil || !exists {
+		return versions, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT version FROM %s ORDER BY version`, c.quotedMigrationTable()))
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return versions, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// appliedVersionsInOrder returns every version recorded in the migration
+// table, in the order in which they were applied. Tables which haven't been
+// upgraded to record that order yet (see upgradeMigrationTable) fall back to
+// version order.
+func appliedVersionsInOrder(c *Config, db *sql.DB) ([]int64, error) {
+	exists, err := migrationTableExists(c, db)
+	if err != nil || !exists {
+		return []int64{}, err
+	}
+
+	columns, err := migrationTableColumnNames(c, db)
+	if err != nil {
+		return nil, err
+	}
+	if !columns[appliedOrderColumn] {
+		return appliedVersions(c, db)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT version FROM %s ORDER BY %s, version`, c.quotedMigrationTable(), appliedOrderColumn))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	versions := []int64{}
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return versions, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// openSession opens a connection pool limited to a single connection, so
+// that every statement run through it shares one database session. This
+// lets a whole run reuse one connection, and keeps session-level state such
+// as advisory locks and SET ROLE in effect from one migration to the next.
+// Callers must not use the session while a transaction on it is open.
+func openSession(c *Config) (*sql.DB, error) {
+	db, err := openConnection(c)
+	if err != nil {
+		if db != nil {
+			db.Close() //nolint:errcheck // already returning an error
+		}
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	return db, nil
+}
+
+// withSession runs fn with a single-connection session (see openSession),
+// holding the migration lock on it for the duration.
+func withSession(c *Config, fn func(db *sql.DB) error) error {
+	db, err := openSession(c)
+	if err != nil {
+		return err
+	}
+	defer db.Close() //nolint:errcheck
+
+	// a dry run changes nothing, so needn't wait for anyone else
+	if c.DryRun {
+		return fn(db)
+	}
+
+	return withMigrationLock(c, db, func() error {
+		return fn(db)
+	})
+}
+
 func openConnection(c *Config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", SQLConnectionString(c))
 	if err != nil {
@@ -563,18 +1234,61 @@ func migrations(c *Config, direction string) ([]Migration, error) {
 	re := regexp.MustCompile("^[0-9]+")
 
 	migrations := []Migration{}
-	files, err := os.ReadDir(c.MigrationFolder)
+	folders, err := environmentFolders(c)
 	if err != nil {
 		return migrations, err
 	}
 
-	for _, file := range files {
-		if match, _ := regexp.MatchString("^[0-9]+_.+\\."+direction+"\\.sql$", file.Name()); match {
+	filenames := map[int64][]string{}
+	for _, folder := range append([]string{""}, folders...) {
+		files, err := readMigrationDir(c, folder)
+		if err != nil {
+			return migrations, err
+		}
+
+		for _, file := range files {
+			if match, _ := regexp.MatchString("^[0-9]+_.+\\."+direction+"\\.sql$", file.Name()); !match || file.IsDir() {
+				continue
+			}
+
 			version, _ := strconv.ParseInt(re.FindString(file.Name()), 10, 64)
-			migrations = append(migrations, Migration{Filename: file.Name(), Version: version})
+			m := Migration{Filename: filepath.Join(folder, file.Name()), Version: version}
+
+			contents, err := readMigration(c, m)
+			if err != nil {
+				return migrations, err
+			}
+			if err := m.applyDirectives(readDirectives(contents)); err != nil {
+				return migrations, fmt.Errorf("%s: %s", m.Filename, err)
+			}
+
+			// migrations in an environment's folder are for that environment
+			if folder != "" && len(m.Environments) == 0 {
+				m.Environments = []string{folder}
+			}
+
+			migrations = append(migrations, m)
+			filenames[version] = append(filenames[version], m.Filename)
 		}
 	}
 
+	for _, m := range registeredMigrations(direction) {
+		migrations = append(migrations, m)
+		filenames[m.Version] = append(filenames[m.Version], m.Filename)
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	duplicates := []string{}
+	for _, m := range migrations {
+		if names := filenames[m.Version]; len(names) > 1 && names[0] == m.Filename {
+			duplicates = append(duplicates, fmt.Sprintf("%d (%s)", m.Version, strings.Join(names, ", ")))
+		}
+	}
+	if len(duplicates) > 0 {
+		return migrations, fmt.Errorf("duplicate migration versions found: %s", strings.Join(duplicates, "; "))
+	}
+
 	return migrations, nil
 }
 
@@ -1,11 +1,14 @@
 package pgmgr
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -193,6 +196,50 @@ func TestInitialize(t *testing.T) {
 	psqlMustExec(t, `SELECT * FROM pgmgr.applied_migrations`)
 }
 
+func TestInitializeUpgradesTable(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	// a table as created by older versions of pgmgr
+	psqlMustExec(t, `CREATE TABLE schema_migrations (version INTEGER NOT NULL UNIQUE);`)
+	psqlMustExec(t, `INSERT INTO schema_migrations (version) VALUES (1);`)
+
+	writeMigration(t, "002_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	// rows from before the upgrade have no details, rather than made-up ones
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT applied_at IS NULL FROM schema_migrations WHERE version = 1); END $$;`)
+
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (
+		SELECT applied_at IS NOT NULL AND duration_ms IS NOT NULL AND applied_by = current_user
+			AND checksum = '`+checksum([]byte(`CREATE TABLE foos (foo_id INTEGER);`))+`'
+			AND pgmgr_version = '`+ToolVersion+`'
+		FROM schema_migrations WHERE version = 2
+	); END $$;`)
+}
+
+func TestRedoUpgradesTable(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+
+	// a table as created by older versions of pgmgr, with 001 applied
+	psqlMustExec(t, `CREATE TABLE schema_migrations (version INTEGER NOT NULL UNIQUE);`)
+	psqlMustExec(t, `CREATE TABLE foos (foo_id INTEGER);`)
+	psqlMustExec(t, `INSERT INTO schema_migrations (version) VALUES (1);`)
+
+	if err := Redo(globalConfig(), 1); err != nil {
+		t.Fatal("Redo failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM foos;`)
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT checksum IS NOT NULL FROM schema_migrations WHERE version = 1); END $$;`)
+}
+
 func TestVersion(t *testing.T) {
 	resetDB(t)
 
@@ -241,6 +288,64 @@ func TestColumnTypeString(t *testing.T) {
 	}
 }
 
+func TestUpgradeTable(t *testing.T) {
+	resetDB(t)
+
+	if err := Initialize(globalConfig()); err != nil {
+		t.Fatal("Initialize failed:", err)
+	}
+	psqlMustExec(t, `INSERT INTO schema_migrations (version) VALUES (1433277961);`)
+
+	config := globalConfig()
+	config.ColumnType = "string"
+
+	_, err := Version(config)
+	if err == nil || !strings.Contains(err.Error(), "upgrade-table --column-type string") {
+		t.Fatal("expected Version to report the column type mismatch, got", err)
+	}
+
+	if err := UpgradeTable(globalConfig(), "string"); err != nil {
+		t.Fatal("UpgradeTable failed:", err)
+	}
+
+	if v, err := Version(config); err != nil || v != 1433277961 {
+		t.Fatal("expected version 1433277961 to survive the conversion, got", v, err)
+	}
+
+	if _, err := Version(globalConfig()); err == nil {
+		t.Fatal("expected Version to report the column type mismatch for an integer config")
+	}
+
+	if err := UpgradeTable(config, "integer"); err != nil {
+		t.Fatal("UpgradeTable back to integer failed:", err)
+	}
+
+	if v, err := Version(globalConfig()); err != nil || v != 1433277961 {
+		t.Fatal("expected version 1433277961 to survive the conversion back, got", v, err)
+	}
+}
+
+func TestMigrateUnsetColumnType(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+
+	// a config not built by LoadConfig, as when pgmgr is embedded
+	config := globalConfig()
+	config.ColumnType = ""
+
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	if err := Migrate(config); err != nil {
+		t.Fatal("second Migrate failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM bars;`)
+}
+
 func TestMigrate(t *testing.T) {
 	resetDB(t)
 	clearMigrationFolder(t)
@@ -274,6 +379,7 @@ func TestMigrate(t *testing.T) {
 		CREATE TABLE bars (bar_id INTEGER);
 		INSERT INTO bars (bar_id) VALUES (4), (5), (6);
 	`)
+	writeMigration(t, "001_this_is_an_older_migration.down.sql", `DROP TABLE bars;`)
 
 	err = Migrate(globalConfig())
 	if err != nil {
@@ -297,22 +403,372 @@ func TestMigrate(t *testing.T) {
 
 	psqlMustNotExec(t, `SELECT * FROM baz;`)
 
-	// rollback the initial migration, since it has the latest version
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected the backdated migration to be the latest applied, got", v, err)
+	}
+
+	// rollback the backdated migration, since it was applied most recently
 	if err := Rollback(globalConfig()); err != nil {
 		t.Fatal("Rollback failed:", err)
 	}
 
-	if err := psqlExec(t, `SELECT * FROM foos;`); err == nil {
-		t.Fatal("Should not have been able to select from foos table")
+	if err := psqlExec(t, `SELECT * FROM bars;`); err == nil {
+		t.Fatal("Should not have been able to select from bars table")
 	}
+	psqlMustExec(t, `SELECT * FROM foos;`)
 
-	v, err := Version(globalConfig())
-	if err != nil || v != 1 {
+	v, err = Version(globalConfig())
+	if err != nil || v != 2 {
 		t.Log(err)
 		t.Fatal("Rollback did not reset version! Still on version ", v)
 	}
 }
 
+func TestMigrateSingleTransaction(t *testing.T) {
+	for _, driver := range []string{"pq", "psql"} {
+		resetDB(t)
+		clearMigrationFolder(t)
+
+		writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+		writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+		writeMigration(t, "003_oops.up.sql", `CREATE TABLE bazs (baz_id INTEGER;`) // syntax error!
+
+		config := globalConfig()
+		config.MigrationDriver = driver
+		config.SingleTransaction = true
+
+		if err := Migrate(config); err == nil {
+			t.Fatal(driver, ": expected the failed migration to raise an error, but got none")
+		}
+
+		// the earlier migrations should have been rolled back with it
+		psqlMustNotExec(t, `SELECT * FROM foos;`)
+		if v, err := Version(config); err != nil || v != -1 {
+			t.Fatal(driver, ": expected no migrations to be applied, got version", v, err)
+		}
+
+		writeMigration(t, "003_oops.up.sql", `CREATE TABLE bazs (baz_id INTEGER);`)
+		if err := Migrate(config); err != nil {
+			t.Fatal(driver, ": Migrate failed:", err)
+		}
+		psqlMustExec(t, `SELECT * FROM bazs;`)
+		if v, err := Version(config); err != nil || v != 3 {
+			t.Fatal(driver, ": expected version 3, got", v, err)
+		}
+
+		writeMigration(t, "004_index_foos.no_txn.up.sql", `CREATE INDEX CONCURRENTLY ON foos (foo_id);`)
+		if err := Migrate(config); err == nil {
+			t.Fatal(driver, ": expected a .no_txn. migration to be refused")
+		}
+	}
+}
+
+func TestMigrateTimeouts(t *testing.T) {
+	for _, driver := range []string{"pq", "psql"} {
+		resetDB(t)
+		clearMigrationFolder(t)
+
+		writeMigration(t, "001_check_timeouts.up.sql", `-- pgmgr:lock_timeout=1234ms
+			DO $$ BEGIN
+				ASSERT current_setting('lock_timeout') = '1234ms';
+				ASSERT current_setting('statement_timeout') = '1min';
+			END $$;`)
+		writeMigration(t, "002_check_timeouts.no_txn.up.sql", `-- pgmgr:statement_timeout=2min
+			DO $$ BEGIN ASSERT current_setting('statement_timeout') = '2min'; END $$;`)
+		writeMigration(t, "003_check_reset.no_txn.up.sql", `
+			DO $$ BEGIN ASSERT current_setting('lock_timeout') = '0'; END $$;`)
+
+		config := globalConfig()
+		config.MigrationDriver = driver
+		config.StatementTimeout = "1min"
+
+		if err := Migrate(config); err != nil {
+			t.Fatal(driver, ": Migrate failed:", err)
+		}
+	}
+}
+
+func TestMigrateEnvironments(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	if err := os.Mkdir(filepath.Join(migrationFolder, "development"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "development/002_seed_foos.up.sql", `INSERT INTO foos (foo_id) VALUES (1);`)
+	writeMigration(t, "003_create_bars.up.sql", "-- pgmgr:environments=production\nCREATE TABLE bars (bar_id INTEGER);")
+
+	config := globalConfig()
+	config.Environment = "development"
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT count(*) FROM foos) = 1; END $$;`)
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+
+	statuses, err := Status(config)
+	if err != nil {
+		t.Fatal("Status failed:", err)
+	}
+	if len(statuses) != 3 || !statuses[1].Applied || !statuses[2].Skipped || statuses[2].Backdated {
+		t.Fatalf("expected the production migration to be reported as skipped, got %+v", statuses)
+	}
+}
+
+func TestMigrateRepeatable(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "R__foo_views.sql", `CREATE OR REPLACE VIEW foo_ids AS SELECT foo_id FROM foos;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM foo_ids;`)
+
+	// an unchanged repeatable migration isn't run again
+	psqlMustExec(t, `DROP VIEW foo_ids;`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	psqlMustNotExec(t, `SELECT * FROM foo_ids;`)
+
+	writeMigration(t, "R__foo_views.sql", `CREATE OR REPLACE VIEW foo_ids AS SELECT foo_id, 1 AS one FROM foos;`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	psqlMustExec(t, `SELECT one FROM foo_ids;`)
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT count(*) FROM schema_migrations_repeatable) = 1; END $$;`)
+}
+
+func TestMigrateGoMigration(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "003_drop_foos.up.sql", `DROP TABLE foos;`)
+	writeMigration(t, "003_drop_foos.down.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+
+	registerTestMigration(t, 2, "copy_foos", func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `CREATE TABLE foo_copies AS SELECT * FROM foos;`)
+		return err
+	}, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DROP TABLE foo_copies;`)
+		return err
+	})
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM foo_copies;`)
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT count(*) FROM schema_migrations) = 3; END $$;`)
+
+	if err := MigrateTo(globalConfig(), 1); err != nil {
+		t.Fatal("MigrateTo failed:", err)
+	}
+	psqlMustNotExec(t, `SELECT * FROM foo_copies;`)
+	psqlMustExec(t, `SELECT * FROM foos;`)
+}
+
+func TestMigrateFromFS(t *testing.T) {
+	for _, driver := range []string{"pq", "psql"} {
+		resetDB(t)
+
+		config := globalConfig()
+		config.MigrationDriver = driver
+		config.MigrationFolder = "migrations"
+		config.MigrationFS = migrationMapFS()
+
+		if err := Migrate(config); err != nil {
+			t.Fatal("Migrate failed with the", driver, "driver:", err)
+		}
+		psqlMustExec(t, `SELECT * FROM foo_ids;`)
+	}
+}
+
+func TestMigrateOutOfOrder(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "002_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	writeMigration(t, "001_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+
+	config := globalConfig()
+	config.OutOfOrder = "error"
+	err := Migrate(config)
+	if err == nil || !strings.Contains(err.Error(), "001_create_bars.up.sql") {
+		t.Fatal("expected Migrate to refuse the backdated migration, got", err)
+	}
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+
+	config.OutOfOrder = "warn"
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM bars;`)
+}
+
+func TestAppliedOrderUpgrade(t *testing.T) {
+	resetDB(t)
+
+	// a table from before applied order was recorded, with rows inserted out
+	// of version order
+	psqlMustExec(t, `CREATE TABLE schema_migrations (version INTEGER NOT NULL UNIQUE, applied_at TIMESTAMP WITH TIME ZONE);`)
+	psqlMustExec(t, `INSERT INTO schema_migrations (version, applied_at) VALUES
+		(3, NULL), (1, NULL), (4, now() - interval '1 hour'), (2, now());`)
+
+	if err := Initialize(globalConfig()); err != nil {
+		t.Fatal("Initialize failed:", err)
+	}
+
+	db, err := openConnection(globalConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close() //nolint:errcheck
+
+	applied, err := appliedVersionsInOrder(globalConfig(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(applied, []int64{1, 3, 4, 2}) {
+		t.Fatal("expected existing rows to be ordered by applied_at then version, got", applied)
+	}
+
+	psqlMustExec(t, `INSERT INTO schema_migrations (version) VALUES (0);`)
+	if v, err := Version(globalConfig()); err != nil || v != 0 {
+		t.Fatal("expected the last inserted version to be the latest, got", v, err)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	writeMigration(t, "002_create_bars.down.sql", `DROP TABLE bars;`)
+	writeMigration(t, "003_create_bazs.up.sql", `CREATE TABLE bazs (baz_id INTEGER);`)
+	writeMigration(t, "003_create_bazs.down.sql", `DROP TABLE bazs;`)
+
+	if err := MigrateTo(globalConfig(), 2); err != nil {
+		t.Fatal("MigrateTo failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM bars;`)
+	psqlMustNotExec(t, `SELECT * FROM bazs;`)
+
+	if err := MigrateTo(globalConfig(), 3); err != nil {
+		t.Fatal("MigrateTo failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM bazs;`)
+
+	// migrating to an older version rolls back everything after it
+	if err := MigrateTo(globalConfig(), 1); err != nil {
+		t.Fatal("MigrateTo failed to roll back:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM foos;`)
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+	psqlMustNotExec(t, `SELECT * FROM bazs;`)
+
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1 after MigrateTo, got", v, err)
+	}
+
+	if err := MigrateTo(globalConfig(), 4); err == nil {
+		t.Fatal("MigrateTo should reject a version with no migration")
+	}
+}
+
+func TestPlanMigrateTo(t *testing.T) {
+	ups := []Migration{
+		{Filename: "001_a.up.sql", Version: 1},
+		{Filename: "002_b.up.sql", Version: 2},
+		{Filename: "003_c.up.sql", Version: 3},
+		{Filename: "004_d.up.sql", Version: 4},
+	}
+	downs := []Migration{
+		{Filename: "003_c.down.sql", Version: 3},
+		{Filename: "004_d.down.sql", Version: 4},
+	}
+
+	steps, err := planMigrateTo(ups, downs, []int64{1, 3, 4}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []plannedStep{
+		{Migration: downs[1], Direction: DOWN},
+		{Migration: downs[0], Direction: DOWN},
+		{Migration: ups[1], Direction: UP},
+	}
+	if len(steps) != len(expected) {
+		t.Fatal("expected steps", expected, "got", steps)
+	}
+	for i := range steps {
+		if !reflect.DeepEqual(steps[i], expected[i]) {
+			t.Fatal("expected steps", expected, "got", steps)
+		}
+	}
+
+	// a backdated migration applied last is rolled back first
+	steps, err = planMigrateTo(ups, downs, []int64{1, 4, 3}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 || steps[0].Migration.Version != 3 || steps[1].Migration.Version != 4 {
+		t.Fatal("expected 3 then 4 to be rolled back, in applied order, got", steps)
+	}
+
+	if _, err := planMigrateTo(ups, downs, []int64{1, 2, 3}, 1); err == nil {
+		t.Fatal("expected an error when a migration to roll back has no down file")
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+
+	config := globalConfig()
+	config.DryRun = true
+	config.DryRunSQL = true
+
+	if err := Migrate(config); err != nil {
+		t.Fatal("dry run of Migrate failed:", err)
+	}
+
+	// nothing should have been touched, not even the migration table
+	psqlMustNotExec(t, `SELECT * FROM foos;`)
+	psqlMustNotExec(t, `SELECT * FROM schema_migrations;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	if err := Rollback(config); err != nil {
+		t.Fatal("dry run of Rollback failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM foos;`)
+}
+
 func TestMigrateColumnTypeString(t *testing.T) {
 	resetDB(t)
 	clearMigrationFolder(t)
@@ -373,6 +829,22 @@ func TestMigrateNoTransaction(t *testing.T) {
 	}
 }
 
+func TestMigrateSharesSession(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	// session-level settings made by one migration should still be in effect
+	// for the next, since the whole run shares a single connection.
+	writeMigration(t, "001_set_name.no_txn.up.sql", `SET application_name = 'pgmgr_shared_session';`)
+	writeMigration(t, "002_check_name.up.sql", `
+		DO $$ BEGIN ASSERT current_setting('application_name') = 'pgmgr_shared_session'; END $$;
+	`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestMigrateCustomMigrationTable(t *testing.T) {
 	resetDB(t)
 	clearMigrationFolder(t)
@@ -423,6 +895,11 @@ func TestMigratePsqlDriver(t *testing.T) {
 		t.Fatal("foos table is not queryable -- does it exist?")
 	}
 
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (
+		SELECT bool_and(applied_at IS NOT NULL AND duration_ms IS NOT NULL AND checksum IS NOT NULL AND pgmgr_version IS NOT NULL)
+		FROM schema_migrations
+	); END $$;`)
+
 	if err := Rollback(config); err != nil {
 		t.Fatal("rollback of 2 failed")
 	}
@@ -532,7 +1009,7 @@ func TestCreateMigration(t *testing.T) {
 	assertFileExists(fmt.Sprint(expectedVersion, "_new_migration.up.sql"))
 	assertFileExists(fmt.Sprint(expectedVersion, "_new_migration.down.sql"))
 
-	expectedStringVersion := time.Now().Format(datetimeFormat)
+	expectedStringVersion := time.Now().UTC().Format(datetimeFormat)
 	config := globalConfig()
 	config.Format = "datetime"
 	err = CreateMigration(config, "rails_style", false)
@@ -543,20 +1020,132 @@ func TestCreateMigration(t *testing.T) {
 	assertFileExists(fmt.Sprint(expectedStringVersion, "_rails_style.up.sql"))
 	assertFileExists(fmt.Sprint(expectedStringVersion, "_rails_style.down.sql"))
 
+	// created in the same second, so its version must be bumped past rails_style's
 	err = CreateMigration(config, "create_index", true)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	assertFileExists(fmt.Sprint(expectedStringVersion, "_create_index.no_txn.up.sql"))
-	assertFileExists(fmt.Sprint(expectedStringVersion, "_create_index.no_txn.down.sql"))
-}
+	ups, err := migrations(config, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := ups[len(ups)-1]
+	if !strings.HasSuffix(last.Filename, "_create_index.no_txn.up.sql") || fmt.Sprint(last.Version) == expectedStringVersion {
+		t.Fatal("expected create_index to be given a version newer than", expectedStringVersion, "but got", last.Filename)
+	}
+	assertFileExists(fmt.Sprint(last.Version, "_create_index.no_txn.down.sql"))
 
-func TestRollback(t *testing.T) {
-	resetDB(t)
+	// an existing migration far in the future still sorts before the new one
 	clearMigrationFolder(t)
-
-	writeMigration(t, "001_a_migration.up.sql", `
+	writeMigration(t, "99999999999999_future.up.sql", ``)
+	err = CreateMigration(globalConfig(), "after_future", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileExists("100000000000000_after_future.up.sql")
+}
+
+func TestConvertLegacyDatetimeFormat(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "20150910940933_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+
+	config := globalConfig()
+	config.ColumnType = "string"
+	config.Format = "datetime"
+
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	if err := ConvertFormat(config, "datetime"); err != nil {
+		t.Fatal("ConvertFormat failed:", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(migrationFolder, "20150910040933_create_foos.up.sql")); err != nil {
+		t.Fatal("expected the legacy migration to be renamed:", err)
+	}
+	if v, err := Version(config); err != nil || v != 20150910040933 {
+		t.Fatal("expected the applied legacy version to be converted, got", v, err)
+	}
+}
+
+func TestConvertFormat(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "1433277961_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "1433277961_create_foos.down.sql", `DROP TABLE foos;`)
+	writeMigration(t, "1433277962_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+
+	config := globalConfig()
+	config.ColumnType = "string"
+
+	if err := MigrateTo(config, 1433277961); err != nil {
+		t.Fatal("MigrateTo failed:", err)
+	}
+
+	if err := ConvertFormat(config, "datetime"); err != nil {
+		t.Fatal("ConvertFormat failed:", err)
+	}
+
+	for _, name := range []string{"20150602204601_create_foos.up.sql", "20150602204601_create_foos.down.sql", "20150602204602_create_bars.up.sql"} {
+		if _, err := os.Stat(filepath.Join(migrationFolder, name)); err != nil {
+			t.Fatal("expected migration to be renamed:", err)
+		}
+	}
+
+	config.Format = "datetime"
+	if v, err := Version(config); err != nil || v != 20150602204601 {
+		t.Fatal("expected the applied version to be converted, got", v, err)
+	}
+
+	// running it again changes nothing
+	if err := ConvertFormat(config, "datetime"); err != nil {
+		t.Fatal("ConvertFormat failed:", err)
+	}
+
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM bars;`)
+
+	if err := ConvertFormat(config, "unix"); err != nil {
+		t.Fatal("ConvertFormat back to unix failed:", err)
+	}
+	if _, err := os.Stat(filepath.Join(migrationFolder, "1433277962_create_bars.up.sql")); err != nil {
+		t.Fatal("expected migration to be renamed back:", err)
+	}
+}
+
+func TestMigrationsDuplicateVersions(t *testing.T) {
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", ``)
+	writeMigration(t, "002_create_bars.up.sql", ``)
+	writeMigration(t, "002_create_bazs.up.sql", ``)
+
+	_, err := migrations(globalConfig(), "up")
+	if err == nil || !strings.Contains(err.Error(), "002_create_bars.up.sql, 002_create_bazs.up.sql") {
+		t.Fatal("expected an error listing the duplicate versions, got", err)
+	}
+
+	if _, err := migrations(globalConfig(), "down"); err != nil {
+		t.Fatal("expected no error for down migrations, got", err)
+	}
+
+	if err := CreateMigration(globalConfig(), "another", false); err == nil {
+		t.Fatal("expected CreateMigration to refuse to run with duplicate versions present")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_a_migration.up.sql", `
 		CREATE TABLE foos (foo_id INTEGER);
 		INSERT INTO foos (foo_id) VALUES (1), (2), (3);
 	`)
@@ -602,6 +1191,515 @@ func TestRollbackFailed(t *testing.T) {
 	}
 }
 
+func TestStatus(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+	writeMigration(t, "003_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrations failed to run:", err)
+	}
+
+	// a backdated migration, as if merged in from another branch, and an
+	// applied version whose file has since been deleted.
+	writeMigration(t, "002_create_bazs.up.sql", `CREATE TABLE bazs (baz_id INTEGER);`)
+	psqlMustExec(t, `INSERT INTO schema_migrations (version) VALUES (4);`)
+
+	statuses, err := Status(globalConfig())
+	if err != nil {
+		t.Fatal("Status failed:", err)
+	}
+
+	if len(statuses) != 4 {
+		t.Fatal("expected 4 statuses, got", statuses)
+	}
+
+	expected := []MigrationStatus{
+		{Migration: Migration{Filename: "001_create_foos.up.sql", Version: 1}, Applied: true, AppliedOrder: 1, HasDown: true},
+		{Migration: Migration{Filename: "002_create_bazs.up.sql", Version: 2}, Backdated: true},
+		{Migration: Migration{Filename: "003_create_bars.up.sql", Version: 3}, Applied: true, AppliedOrder: 2},
+		{Migration: Migration{Version: 4}, Applied: true, AppliedOrder: 3, Orphaned: true},
+	}
+
+	for i, s := range statuses {
+		if !reflect.DeepEqual(s, expected[i]) {
+			t.Fatalf("expected status %+v, got %+v", expected[i], s)
+		}
+	}
+}
+
+func TestRollbackSteps(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	writeMigration(t, "002_create_bars.down.sql", `DROP TABLE bars;`)
+	writeMigration(t, "003_create_bazs.up.sql", `CREATE TABLE bazs (baz_id INTEGER);`)
+	writeMigration(t, "003_create_bazs.down.sql", `DROP TABLE bazs;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrations failed to run:", err)
+	}
+
+	if err := RollbackSteps(globalConfig(), 2); err != nil {
+		t.Fatal("RollbackSteps failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM foos;`)
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+	psqlMustNotExec(t, `SELECT * FROM bazs;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrations failed to run:", err)
+	}
+
+	if err := RollbackTo(globalConfig(), 1); err != nil {
+		t.Fatal("RollbackTo failed:", err)
+	}
+
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1 after RollbackTo, got", v, err)
+	}
+}
+
+func TestRollbackStepsMissingDown(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	writeMigration(t, "002_create_bars.down.sql", `DROP TABLE bars;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrations failed to run:", err)
+	}
+
+	if err := RollbackSteps(globalConfig(), 2); err == nil {
+		t.Fatal("RollbackSteps should fail when a migration has no down file")
+	}
+
+	// nothing should have been reverted
+	psqlMustExec(t, `SELECT * FROM bars;`)
+}
+
+func TestRedo(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrations failed to run:", err)
+	}
+
+	// edit the applied migration; redo should pick up the change
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER, val BOOLEAN);`)
+
+	if err := Redo(globalConfig(), 1); err != nil {
+		t.Fatal("Redo failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT val FROM foos;`)
+
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1 after Redo, got", v, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	mismatches, err := Verify(globalConfig())
+	if err != nil {
+		t.Fatal("Verify failed:", err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatal("expected no mismatches, got", mismatches)
+	}
+
+	// edit the applied migration and add a new one
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER, val BOOLEAN);`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+
+	mismatches, err = Verify(globalConfig())
+	if err != nil {
+		t.Fatal("Verify failed:", err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Version != 1 {
+		t.Fatal("expected a mismatch for version 1, got", mismatches)
+	}
+
+	if err := Migrate(globalConfig()); err == nil {
+		t.Fatal("Migrate should refuse to run when an applied migration was modified")
+	}
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+
+	config := globalConfig()
+	config.AllowModified = true
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate with AllowModified failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM bars;`)
+}
+
+func TestMigrationLock(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+
+	config := globalConfig()
+	config.AdvisoryLockTimeout = 1
+
+	// hold the lock from another session, as a concurrent pgmgr would
+	db, err := openConnection(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close() //nolint:errcheck
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`SELECT pg_advisory_lock($1)`, migrationLockKey(config)); err != nil {
+		t.Fatal("could not take advisory lock:", err)
+	}
+
+	if err := Migrate(config); err == nil {
+		t.Fatal("Migrate should time out while another session holds the lock")
+	}
+	psqlMustNotExec(t, `SELECT * FROM foos;`)
+
+	if _, err := db.Exec(`SELECT pg_advisory_unlock($1)`, migrationLockKey(config)); err != nil {
+		t.Fatal("could not release advisory lock:", err)
+	}
+
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed after the lock was released:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM foos;`)
+}
+
+func TestMigrationLockPsqlDriver(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+
+	config := globalConfig()
+	config.MigrationDriver = "psql"
+
+	if err := Migrate(config); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	if err := Rollback(config); err != nil {
+		t.Fatal("Rollback failed:", err)
+	}
+
+	// the lock should have been released again
+	psqlMustExec(t, fmt.Sprintf(
+		`DO $$ BEGIN ASSERT pg_try_advisory_lock(%d); END $$;`,
+		migrationLockKey(config),
+	))
+}
+
+func TestScriptApplies(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER)`)
+	writeMigration(t, "002_create_bars.down.sql", `DROP TABLE bars;`)
+
+	scriptFile := filepath.Join(t.TempDir(), "deploy.sql")
+	writeScriptFile := func(write func(f *os.File) error) {
+		f, err := os.Create(scriptFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() //nolint:errcheck
+		if err := write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeScriptFile(func(f *os.File) error { return Script(globalConfig(), f, -1, 2) })
+	if err := testSh(t, "psql", []string{"-d", testDBName, "-f", scriptFile}); err != nil {
+		t.Fatal("deploy script failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM bars;`)
+	v, err := Version(globalConfig())
+	if err != nil || v != 2 {
+		t.Fatal("expected version 2 after running the script, got", v, err)
+	}
+
+	// running it again should fail the guard, since the database has moved on
+	if err := testSh(t, "psql", []string{"-d", testDBName, "-f", scriptFile}); err == nil {
+		t.Fatal("deploy script should refuse to run against the wrong version")
+	}
+
+	writeScriptFile(func(f *os.File) error { return RollbackScript(globalConfig(), f, 2, 1) })
+	if err := testSh(t, "psql", []string{"-d", testDBName, "-f", scriptFile}); err != nil {
+		t.Fatal("rollback script failed:", err)
+	}
+
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+	psqlMustExec(t, `SELECT * FROM foos;`)
+}
+
+func TestScriptFromHighestAppliedVersion(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	writeMigration(t, "002_create_bars.down.sql", `DROP TABLE bars;`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	// a backdated migration, applied last, is the version Version reports
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate failed:", err)
+	}
+
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1 after the backdated migration, got", v, err)
+	}
+
+	writeRollbackScript := func(from int64) string {
+		scriptFile := filepath.Join(t.TempDir(), "rollback.sql")
+		f, err := os.Create(scriptFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RollbackScript(globalConfig(), f, from, -1); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return scriptFile
+	}
+
+	// scripts start from the highest applied version, not the reported one
+	if err := testSh(t, "psql", []string{"-d", testDBName, "-f", writeRollbackScript(v)}); err == nil {
+		t.Fatal("rollback script from the most recently applied version should fail")
+	}
+	psqlMustExec(t, `SELECT * FROM foos;`)
+	psqlMustExec(t, `SELECT * FROM bars;`)
+
+	if err := testSh(t, "psql", []string{"-d", testDBName, "-f", writeRollbackScript(2)}); err != nil {
+		t.Fatal("rollback script from the highest applied version failed:", err)
+	}
+	psqlMustNotExec(t, `SELECT * FROM foos;`)
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+}
+
+func TestMarkApplied(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+
+	if err := MarkApplied(globalConfig(), 2, false); err == nil {
+		t.Fatal("MarkApplied should reject a version with no migration file")
+	}
+
+	if err := MarkApplied(globalConfig(), 1, false); err != nil {
+		t.Fatal("MarkApplied failed:", err)
+	}
+
+	if err := MarkApplied(globalConfig(), 1, false); err == nil {
+		t.Fatal("MarkApplied should reject a version which is already applied")
+	}
+
+	// the migration was recorded, but not run
+	psqlMustNotExec(t, `SELECT * FROM foos;`)
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1 after MarkApplied, got", v, err)
+	}
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT duration_ms IS NULL FROM schema_migrations WHERE version = 1); END $$;`)
+
+	// the recorded checksum should match the file
+	mismatches, err := Verify(globalConfig())
+	if err != nil || len(mismatches) != 0 {
+		t.Fatal("expected no checksum mismatches after MarkApplied, got", mismatches, err)
+	}
+
+	if err := MarkApplied(globalConfig(), 2, true); err != nil {
+		t.Fatal("MarkApplied with force failed:", err)
+	}
+
+	if err := MarkUnapplied(globalConfig(), 2, false); err == nil {
+		t.Fatal("MarkUnapplied should reject a version with no migration file")
+	}
+
+	if err := MarkUnapplied(globalConfig(), 2, true); err != nil {
+		t.Fatal("MarkUnapplied with force failed:", err)
+	}
+
+	if err := MarkUnapplied(globalConfig(), 1, false); err != nil {
+		t.Fatal("MarkUnapplied failed:", err)
+	}
+
+	if err := MarkUnapplied(globalConfig(), 1, false); err == nil {
+		t.Fatal("MarkUnapplied should reject a version which is not applied")
+	}
+
+	v, err = Version(globalConfig())
+	if err != nil || v != -1 {
+		t.Fatal("expected version -1 after MarkUnapplied, got", v, err)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	// a legacy database, whose schema already reflects the first migration
+	psqlMustExec(t, `CREATE TABLE foos (foo_id INTEGER);`)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+
+	if err := Baseline(globalConfig(), 3, false); err == nil {
+		t.Fatal("Baseline should reject a version with no migration")
+	}
+
+	if err := Baseline(globalConfig(), 1, false); err != nil {
+		t.Fatal("Baseline failed:", err)
+	}
+
+	v, err := Version(globalConfig())
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1 after Baseline, got", v, err)
+	}
+	psqlMustExec(t, `DO $$ BEGIN ASSERT (SELECT duration_ms IS NULL FROM schema_migrations WHERE version = 1); END $$;`)
+
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate after Baseline failed:", err)
+	}
+
+	psqlMustExec(t, `SELECT * FROM bars;`)
+}
+
+func TestBaselineVerifySchema(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	psqlMustExec(t, `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+
+	if err := os.WriteFile(dumpFile, []byte(`CREATE TABLE foos (foo_id BIGINT);`), 0644); err != nil {
+		t.Fatal("Could not write dump file:", err)
+	}
+
+	if err := Baseline(globalConfig(), 1, true); err == nil {
+		t.Fatal("Baseline should fail when the schema does not match the dump file")
+	}
+	psqlMustNotExec(t, `SELECT * FROM schema_migrations;`)
+
+	if err := os.WriteFile(dumpFile, []byte(`CREATE TABLE foos (foo_id INTEGER);`), 0644); err != nil {
+		t.Fatal("Could not write dump file:", err)
+	}
+
+	if err := Baseline(globalConfig(), 1, true); err != nil {
+		t.Fatal("Baseline failed when the schema matched the dump file:", err)
+	}
+}
+
+func TestSquash(t *testing.T) {
+	resetDB(t)
+	clearMigrationFolder(t)
+
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "001_create_foos.down.sql", `DROP TABLE foos;`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	writeMigration(t, "003_create_bazs.up.sql", `CREATE TABLE bazs (baz_id INTEGER);`)
+
+	// this database has applied the migrations to be squashed
+	if err := MigrateTo(globalConfig(), 2); err != nil {
+		t.Fatal("MigrateTo failed:", err)
+	}
+
+	if err := Squash(globalConfig(), 3, ""); err != nil {
+		t.Fatal("Squash failed:", err)
+	}
+
+	for _, archived := range []string{"001_create_foos.up.sql", "001_create_foos.down.sql", "002_create_bars.up.sql"} {
+		if _, err := os.Stat(filepath.Join(migrationFolder, "archive", archived)); err != nil {
+			t.Fatal("expected squashed file to be archived:", err)
+		}
+	}
+
+	ups, err := migrations(globalConfig(), "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ups) != 2 || ups[0].Filename != "002_squashed_baseline.up.sql" {
+		t.Fatal("expected the baseline and the unsquashed migration, got", ups)
+	}
+
+	// the baseline should be treated as applied, rather than run again
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate after Squash failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM bazs;`)
+
+	statuses, err := Status(globalConfig())
+	if err != nil {
+		t.Fatal("Status failed:", err)
+	}
+	for _, s := range statuses {
+		if s.Orphaned {
+			t.Fatal("squashed versions should not be reported as orphaned, got", s)
+		}
+	}
+
+	// on a new database, the baseline creates everything it replaced
+	resetDB(t)
+	if err := Migrate(globalConfig()); err != nil {
+		t.Fatal("Migrate of new database after Squash failed:", err)
+	}
+	psqlMustExec(t, `SELECT * FROM foos;`)
+	psqlMustExec(t, `SELECT * FROM bars;`)
+	psqlMustExec(t, `SELECT * FROM bazs;`)
+
+	// a database which applied only some of the squashed migrations is
+	// refused, rather than left without the rest
+	resetDB(t)
+	if err := Initialize(globalConfig()); err != nil {
+		t.Fatal("Initialize failed:", err)
+	}
+	psqlMustExec(t, `CREATE TABLE foos (foo_id INTEGER); INSERT INTO schema_migrations (version) VALUES (1);`)
+
+	err = Migrate(globalConfig())
+	if err == nil || !strings.Contains(err.Error(), "not applied: 2") {
+		t.Fatal("expected Migrate to refuse a partly applied baseline, got", err)
+	}
+	psqlMustNotExec(t, `SELECT * FROM bars;`)
+	psqlMustNotExec(t, `SELECT * FROM bazs;`)
+}
+
 // redundant, but I'm also lazy
 func testSh(t *testing.T, command string, args []string) error {
 	c := exec.Command(command, args...)
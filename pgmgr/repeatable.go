@@ -0,0 +1,242 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// repeatableFileRegex matches repeatable migrations, like Flyway's: they have
+// no version, and are re-applied whenever they change.
+var repeatableFileRegex = regexp.MustCompile(`^R__.+\.sql$`)
+
+// repeatableTableSuffix is appended to MigrationTable to name the table which
+// records the repeatable migrations applied.
+const repeatableTableSuffix = "_repeatable"
+
+func (config *Config) quotedRepeatableTable() string {
+	repeatable := *config
+	repeatable.MigrationTable += repeatableTableSuffix
+	return repeatable.quotedMigrationTable()
+}
+
+// repeatableMigrations returns the repeatable migrations in the migration
+// folder and its environment folders, in the order they are applied: by
+// filename, regardless of folder.
+func repeatableMigrations(c *Config) ([]Migration, error) {
+	folders, err := environmentFolders(c)
+	if err != nil {
+		return nil, err
+	}
+
+	repeatables := []Migration{}
+	for _, folder := range append([]string{""}, folders...) {
+		files, err := readMigrationDir(c, folder)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.IsDir() || !repeatableFileRegex.MatchString(file.Name()) {
+				continue
+			}
+
+			m := Migration{Filename: filepath.Join(folder, file.Name())}
+
+			contents, err := readMigration(c, m)
+			if err != nil {
+				return nil, err
+			}
+			if err := m.applyDirectives(readDirectives(contents)); err != nil {
+				return nil, fmt.Errorf("%s: %s", m.Filename, err)
+			}
+
+			if folder != "" && len(m.Environments) == 0 {
+				m.Environments = []string{folder}
+			}
+
+			repeatables = append(repeatables, m)
+		}
+	}
+
+	sort.SliceStable(repeatables, func(i, j int) bool {
+		return filepath.Base(repeatables[i].Filename) < filepath.Base(repeatables[j].Filename)
+	})
+
+	return repeatables, nil
+}
+
+// applyRepeatables applies each repeatable migration for the configured
+// environment which is new, or has changed since it was last applied. On a
+// dry run, they are printed instead.
+func applyRepeatables(c *Config, db *sql.DB) error {
+	repeatables, err := repeatableMigrations(c)
+	if err != nil {
+		return err
+	}
+
+	repeatables = forEnvironment(c, repeatables)
+	if len(repeatables) == 0 {
+		return nil
+	}
+
+	// repeatable migrations run in their own transactions, after any single
+	// transaction for the versioned migrations has been committed
+	own := *c
+	own.SingleTransaction = false
+	c = &own
+
+	if !c.DryRun {
+		if err := initializeRepeatableTable(c, db); err != nil {
+			return err
+		}
+	}
+
+	applied, err := appliedRepeatableChecksums(c, db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range repeatables {
+		contents, err := readMigration(c, m)
+		if err != nil {
+			return err
+		}
+
+		sum := checksum(contents)
+		if applied[m.Filename] == sum {
+			continue
+		}
+
+		if c.DryRun {
+			printRepeatable(c, m, contents)
+			continue
+		}
+
+		fmt.Println("== Applying", m.Filename, "==")
+		t0 := time.Now()
+
+		if err := applyRepeatable(c, db, m, contents); err != nil {
+			printFailedMigrationMessage(err, MIGRATION)
+			return err
+		}
+
+		fmt.Println("== Completed in", time.Since(t0).Nanoseconds()/1e6, "ms ==")
+	}
+
+	return nil
+}
+
+func applyRepeatable(c *Config, db *sql.DB, m Migration, contents []byte) error {
+	sum := checksum(contents)
+
+	if c.MigrationDriver == "psql" {
+		return runByPsql(c, m, contents, upsertRepeatableSQL(c, m.Filename, sum))
+	}
+
+	return inMigrationTransaction(db, m, func(exec execer) error {
+		return execMigration(c, exec, m, contents, func(exec execer, duration time.Duration) error {
+			return upsertRepeatable(c, exec, m.Filename, sum, duration)
+		})
+	})
+}
+
+// printRepeatable describes a repeatable migration for a dry run, with its
+// SQL if DryRunSQL is set.
+func printRepeatable(c *Config, m Migration, contents []byte) {
+	txn := "in a transaction"
+	if !m.WrapInTransaction() {
+		txn = "without a transaction"
+	}
+
+	if !c.DryRunSQL {
+		fmt.Printf("== Would apply %s (%s) ==\n", m.Filename, txn)
+		return
+	}
+
+	setTimeouts, _ := timeoutSQL(c, m)
+
+	script := ""
+	if setTimeouts != "" {
+		script += setTimeouts + "\n"
+	}
+	script += startTimerSQL + ";\n" + string(contents) + "\n;\n" + upsertRepeatableSQL(c, m.Filename, checksum(contents)) + "\n"
+
+	if m.WrapInTransaction() {
+		script = "BEGIN;\n" + script + "COMMIT;\n"
+	}
+
+	fmt.Printf("-- == Would apply %s (%s) ==\n%s\n", m.Filename, txn, script)
+}
+
+func initializeRepeatableTable(c *Config, db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			name TEXT PRIMARY KEY,
+			checksum CHARACTER VARYING (64) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+			duration_ms BIGINT,
+			applied_by TEXT DEFAULT current_user,
+			pgmgr_version TEXT
+		);`,
+		c.quotedRepeatableTable(),
+	))
+	return err
+}
+
+// appliedRepeatableChecksums returns the checksum each repeatable migration
+// had when it was last applied, by filename.
+func appliedRepeatableChecksums(c *Config, db *sql.DB) (map[string]string, error) {
+	checksums := map[string]string{}
+
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, c.quotedRepeatableTable()).Scan(&exists); err != nil || !exists {
+		return checksums, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT name, checksum FROM %s`, c.quotedRepeatableTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var name, sum string
+		if err := rows.Scan(&name, &sum); err != nil {
+			return nil, err
+		}
+		checksums[name] = sum
+	}
+
+	return checksums, rows.Err()
+}
+
+const upsertRepeatableFormat = `INSERT INTO %s (name, checksum, duration_ms, pgmgr_version) VALUES (%s, %s, %s, %s) ` +
+	`ON CONFLICT (name) DO UPDATE SET checksum = EXCLUDED.checksum, applied_at = now(), duration_ms = EXCLUDED.duration_ms, ` +
+	`applied_by = current_user, pgmgr_version = EXCLUDED.pgmgr_version;`
+
+func upsertRepeatable(c *Config, exec execer, name, checksum string, duration time.Duration) error {
+	_, err := exec.Exec(
+		fmt.Sprintf(upsertRepeatableFormat, c.quotedRepeatableTable(), "$1", "$2", "$3", "$4"),
+		name, checksum, duration.Milliseconds(), ToolVersion,
+	)
+	return err
+}
+
+// upsertRepeatableSQL returns a self-contained equivalent of upsertRepeatable,
+// for use in scripts run through psql.
+func upsertRepeatableSQL(c *Config, name, checksum string) string {
+	return fmt.Sprintf(
+		upsertRepeatableFormat,
+		c.quotedRepeatableTable(),
+		pq.QuoteLiteral(name),
+		pq.QuoteLiteral(checksum),
+		`(EXTRACT(EPOCH FROM clock_timestamp() - current_setting('pgmgr.started_at', true)::timestamptz) * 1000)::bigint`,
+		pq.QuoteLiteral(ToolVersion),
+	)
+}
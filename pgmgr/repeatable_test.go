@@ -0,0 +1,45 @@
+package pgmgr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRepeatableMigrations(t *testing.T) {
+	clearMigrationFolder(t)
+
+	if err := os.Mkdir(filepath.Join(migrationFolder, "development"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeMigration(t, "001_create_foos.up.sql", ``)
+	writeMigration(t, "R__foo_views.sql", ``)
+	writeMigration(t, "development/R__bar_views.sql", ``)
+	writeMigration(t, "R__foo_functions.sql", "-- pgmgr:no-transaction\nCREATE FUNCTION f() RETURNS int AS 'SELECT 1' LANGUAGE sql;")
+
+	c := globalConfig()
+	c.EnvironmentFolders = []string{"development"}
+
+	repeatables, err := repeatableMigrations(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Migration{
+		{Filename: "development/R__bar_views.sql", Environments: []string{"development"}},
+		{Filename: "R__foo_functions.sql", NoTransaction: true},
+		{Filename: "R__foo_views.sql"},
+	}
+	if !reflect.DeepEqual(repeatables, expected) {
+		t.Fatalf("expected repeatable migrations %+v, got %+v", expected, repeatables)
+	}
+}
+
+func TestQuotedRepeatableTable(t *testing.T) {
+	c := &Config{MigrationTable: "pgmgr.migrations"}
+	if actual := c.quotedRepeatableTable(); actual != `"pgmgr"."migrations_repeatable"` {
+		t.Fatal("expected the repeatable table alongside the migration table, got", actual)
+	}
+}
@@ -0,0 +1,130 @@
+package pgmgr
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// withScratchDatabase creates a new, empty database next to the configured
+// one, runs fn with a copy of the config pointing at it, and then drops it.
+func withScratchDatabase(c *Config, fn func(scratch *Config) error) (retErr error) {
+	scratch := *c
+	scratch.Database = fmt.Sprintf("%s_pgmgr_scratch_%d", c.Database, time.Now().UnixNano())
+
+	if err := Create(&scratch); err != nil {
+		return fmt.Errorf("could not create scratch database %s: %w", scratch.Database, err)
+	}
+
+	defer func() {
+		if err := Drop(&scratch); err != nil && retErr == nil {
+			retErr = fmt.Errorf("could not drop scratch database %s: %w", scratch.Database, err)
+		}
+	}()
+
+	return fn(&scratch)
+}
+
+// schemaDump returns the database's schema as dumped by pg_dump, excluding
+// the migration table, ownership, privileges and anything else which differs
+// between two databases with the same structure.
+func schemaDump(c *Config) (string, error) {
+	dump, err := rawSchemaDump(c)
+	if err != nil {
+		return "", err
+	}
+
+	return cleanSchemaDump(dump), nil
+}
+
+// rawSchemaDump returns the database's schema as dumped by pg_dump, excluding
+// the migration table, ownership and privileges.
+func rawSchemaDump(c *Config) (string, error) {
+	if err := c.DumpToEnv(); err != nil {
+		return "", err
+	}
+
+	args := []string{"--schema-only", "--no-owner", "-x", "-T", c.MigrationTable}
+	for _, schema := range c.DumpConfig.ExcludeSchemas {
+		args = append(args, "-N", schema)
+	}
+
+	output, err := shRead("pg_dump", args)
+	if err != nil {
+		return "", fmt.Errorf("pg_dump failed: %w: %s", err, *output)
+	}
+
+	return string(*output), nil
+}
+
+// cleanSchemaDump strips comments, blank lines and psql meta-commands (which
+// newer versions of pg_dump emit with random keys) from a schema dump.
+func cleanSchemaDump(dump string) string {
+	lines := []string{}
+	for _, line := range strings.Split(dump, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") || strings.HasPrefix(trimmed, `\`) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// verifySchemaMatchesDump checks that the database's schema matches the one
+// in the dump file, by loading the dump file into a scratch database and
+// comparing the schemas of the two.
+func verifySchemaMatchesDump(c *Config) error {
+	if _, err := os.Stat(c.DumpConfig.GetDumpFile()); err != nil {
+		return fmt.Errorf("cannot verify the schema against the dump file: %w", err)
+	}
+
+	actual, err := schemaDump(c)
+	if err != nil {
+		return err
+	}
+
+	var expected string
+	err = withScratchDatabase(c, func(scratch *Config) error {
+		if err := Load(scratch); err != nil {
+			return err
+		}
+
+		expected, err = schemaDump(scratch)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if line, ok := firstDifference(actual, expected); ok {
+		return fmt.Errorf(
+			"the schema of database %s does not match the dump file %s; first difference at line %d",
+			c.Database, c.DumpConfig.GetDumpFile(), line,
+		)
+	}
+
+	return nil
+}
+
+// firstDifference returns the first line number (counting from 1) at which
+// the two strings differ, if they do.
+func firstDifference(a, b string) (int, bool) {
+	if a == b {
+		return 0, false
+	}
+
+	aLines, bLines := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(aLines) && i < len(bLines); i++ {
+		if aLines[i] != bLines[i] {
+			return i + 1, true
+		}
+	}
+
+	if len(aLines) < len(bLines) {
+		return len(aLines) + 1, true
+	}
+	return len(bLines) + 1, true
+}
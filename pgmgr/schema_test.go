@@ -0,0 +1,38 @@
+package pgmgr
+
+import "testing"
+
+func TestCleanSchemaDump(t *testing.T) {
+	dump := `--
+-- PostgreSQL database dump
+--
+\restrict abc123
+
+SET statement_timeout = 0;
+
+CREATE TABLE public.foos (
+    foo_id integer
+);
+
+\unrestrict abc123
+`
+
+	expected := "SET statement_timeout = 0;\nCREATE TABLE public.foos (\n    foo_id integer\n);"
+	if actual := cleanSchemaDump(dump); actual != expected {
+		t.Fatalf("expected cleaned dump %q, got %q", expected, actual)
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	if _, differ := firstDifference("a\nb", "a\nb"); differ {
+		t.Fatal("identical strings should not differ")
+	}
+
+	if line, differ := firstDifference("a\nb\nc", "a\nx\nc"); !differ || line != 2 {
+		t.Fatal("expected a difference at line 2, got", line)
+	}
+
+	if line, differ := firstDifference("a\nb", "a\nb\nc"); !differ || line != 3 {
+		t.Fatal("expected a difference at line 3, got", line)
+	}
+}
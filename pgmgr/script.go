@@ -0,0 +1,174 @@
+package pgmgr
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Script writes a standalone psql script which migrates a database at
+// version `from` to version `to`, for databases which can only be changed by
+// running reviewed SQL by hand. It contains every up migration in between,
+// with the same transaction boundaries and version bookkeeping that Migrate
+// would use, and fails without changing anything unless `from` is the
+// highest version applied to the database. Use -1 for a database with no
+// migrations applied, and math.MaxInt64 to migrate to the latest version.
+func Script(c *Config, w io.Writer, from, to int64) error {
+	if to < from {
+		return fmt.Errorf("cannot script a migration from version %d down to %d; use a rollback script instead", from, to)
+	}
+
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return err
+	}
+	ups = forEnvironment(c, ups)
+
+	if to != math.MaxInt64 && !containsVersion(ups, to) {
+		return fmt.Errorf("no migration found with version %d", to)
+	}
+
+	steps := []plannedStep{}
+	for _, m := range ups {
+		if m.Version > from && m.Version <= to {
+			steps = append(steps, plannedStep{Migration: m, Direction: UP})
+		}
+	}
+
+	return writeScript(c, w, from, steps)
+}
+
+// RollbackScript writes a standalone psql script which rolls a database at
+// version `from` back to version `to`, reverting every migration in between,
+// highest version first. Like Script, it fails without changing anything
+// unless `from` is the highest version applied to the database.
+func RollbackScript(c *Config, w io.Writer, from, to int64) error {
+	if to > from {
+		return fmt.Errorf("cannot script a rollback from version %d up to %d; use a migration script instead", from, to)
+	}
+
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return err
+	}
+	ups = forEnvironment(c, ups)
+
+	downs, err := migrations(c, "down")
+	if err != nil {
+		return err
+	}
+
+	versions := []int64{}
+	for _, m := range ups {
+		if m.Version > to && m.Version <= from {
+			versions = append(versions, m.Version)
+		}
+	}
+	sortVersionsDescending(versions)
+
+	steps, err := rollbackSteps(downs, versions)
+	if err != nil {
+		return err
+	}
+
+	return writeScript(c, w, from, steps)
+}
+
+func writeScript(c *Config, w io.Writer, from int64, steps []plannedStep) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "-- Generated by pgmgr %s. Run with: psql -f <this file>\n", ToolVersion)
+	b.WriteString("\\set ON_ERROR_STOP on\n\n")
+	b.WriteString(versionGuardSQL(c, from) + "\n\n")
+
+	if len(steps) > 0 && steps[0].Direction == UP {
+		b.WriteString(migrationTableSQL(c) + "\n\n")
+	}
+
+	for _, step := range steps {
+		sql, err := stepSQL(c, step)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "-- == %s ==\n%s\n", step.Migration.Filename, sql)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// versionGuardSQL returns a block which raises an error unless the highest
+// version applied to the database is the given one, treating a missing
+// migration table as version -1. Scripts cover a range of versions, so this
+// is the highest applied version rather than the most recently applied one
+// reported by Version, which differs after a backdated migration.
+func versionGuardSQL(c *Config, version int64) string {
+	table := c.quotedMigrationTable()
+	return fmt.Sprintf(`DO $$
+DECLARE
+  current_version TEXT := '-1';
+BEGIN
+  IF to_regclass(%s) IS NOT NULL THEN
+    EXECUTE %s INTO current_version;
+  END IF;
+
+  IF current_version <> '%d' THEN
+    RAISE EXCEPTION 'pgmgr: expected the database to be at version %d, but it is at version %%', current_version;
+  END IF;
+END
+$$;`,
+		pq.QuoteLiteral(table),
+		pq.QuoteLiteral(fmt.Sprintf(`SELECT COALESCE(MAX(version)::text, '-1') FROM %s`, table)),
+		version, version,
+	)
+}
+
+// migrationTableSQL returns statements which create the migration table as
+// Initialize would, or upgrade an existing one, if needed.
+func migrationTableSQL(c *Config) string {
+	table := c.quotedMigrationTable()
+	stmts := []string{}
+
+	if strings.Contains(c.MigrationTable, ".") {
+		schema := strings.SplitN(c.MigrationTable, ".", 2)[0]
+		stmts = append(stmts, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", pq.QuoteIdentifier(schema)))
+	}
+
+	stmts = append(stmts, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version %s NOT NULL UNIQUE);", table, c.versionColumnType()))
+	for _, col := range migrationTableColumns {
+		if col.backfill != "" {
+			stmts = append(stmts, addBackfilledColumnSQL(table, col.name, col.dataType, col.backfill))
+			continue
+		}
+
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;", table, col.name, col.dataType))
+		if col.defaultValue != "" {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", table, col.name, col.defaultValue))
+		}
+	}
+
+	return strings.Join(stmts, "\n")
+}
+
+// addBackfilledColumnSQL returns a block which adds a column and runs its
+// backfill, as upgradeMigrationTable would, only if the column is missing.
+func addBackfilledColumnSQL(table, name, dataType, backfill string) string {
+	return fmt.Sprintf(`DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_catalog.pg_attribute
+    WHERE attrelid = to_regclass(%s) AND attname = %s AND NOT attisdropped
+  ) THEN
+    ALTER TABLE %s ADD COLUMN %s %s;
+    %s;
+  END IF;
+END
+$$;`,
+		pq.QuoteLiteral(table), pq.QuoteLiteral(name),
+		table, name, dataType,
+		fmt.Sprintf(backfill, table),
+	)
+}
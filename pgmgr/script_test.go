@@ -0,0 +1,68 @@
+package pgmgr
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	clearMigrationFolder(t)
+	writeMigration(t, "001_create_foos.up.sql", `CREATE TABLE foos (foo_id INTEGER);`)
+	writeMigration(t, "002_create_bars.up.sql", `CREATE TABLE bars (bar_id INTEGER);`)
+	writeMigration(t, "002_create_bars.down.sql", `DROP TABLE bars;`)
+	writeMigration(t, "003_index_bars.no_txn.up.sql", `CREATE INDEX CONCURRENTLY ON bars (bar_id);`)
+	writeMigration(t, "003_index_bars.no_txn.down.sql", `DROP INDEX bars_bar_id_idx;`)
+
+	var b strings.Builder
+	if err := Script(globalConfig(), &b, 1, math.MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	script := b.String()
+
+	// an existing migration table gets the same backfill Initialize would run
+	if !strings.Contains(script, "ALTER TABLE \"schema_migrations\" ADD COLUMN applied_order BIGSERIAL;\n    UPDATE") {
+		t.Fatal("expected the script to backfill applied_order when adding it, got:\n", script)
+	}
+
+	if strings.Contains(script, "CREATE TABLE foos") {
+		t.Fatal("script should not include migrations at or before the starting version:\n", script)
+	}
+
+	guard := strings.Index(script, "expected the database to be at version 1")
+	bars := strings.Index(script, "CREATE TABLE bars")
+	index := strings.Index(script, "CREATE INDEX CONCURRENTLY")
+	if guard < 0 || bars < 0 || index < 0 || !(guard < bars && bars < index) {
+		t.Fatal("expected the version guard followed by each migration in order, got:\n", script)
+	}
+
+	if !strings.Contains(script, "VALUES ('3', ") {
+		t.Fatal("expected the script to record version 3, got:\n", script)
+	}
+
+	// the .no_txn. migration must not be wrapped in a transaction
+	if strings.Contains(script[index:], "BEGIN;") {
+		t.Fatal("expected no transaction around the .no_txn. migration, got:\n", script)
+	}
+
+	b.Reset()
+	if err := RollbackScript(globalConfig(), &b, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	script = b.String()
+
+	dropIndex := strings.Index(script, "DROP INDEX")
+	dropBars := strings.Index(script, "DROP TABLE bars")
+	if dropIndex < 0 || dropBars < 0 || dropIndex > dropBars {
+		t.Fatal("expected the rollback script to revert the latest migration first, got:\n", script)
+	}
+
+	if !strings.Contains(script, "expected the database to be at version 3") {
+		t.Fatal("expected the rollback script to guard on version 3, got:\n", script)
+	}
+
+	// version 1 has no down migration
+	if err := RollbackScript(globalConfig(), &b, 3, -1); err == nil {
+		t.Fatal("expected an error when a migration to roll back has no down file")
+	}
+}
@@ -0,0 +1,116 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// checkSingleTransaction returns an error if any of the steps can't run in a
+// transaction, listing them.
+func checkSingleTransaction(steps []plannedStep) error {
+	filenames := []string{}
+	for _, step := range steps {
+		if !step.Migration.WrapInTransaction() {
+			filenames = append(filenames, step.Migration.Filename)
+		}
+	}
+
+	if len(filenames) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"these migrations cannot run in a transaction, so cannot be run with --single-transaction:\n  %s",
+		strings.Join(filenames, "\n  "),
+	)
+}
+
+// runStepsInSingleTransaction runs every step in one transaction, so that if
+// any of them fails, none of them take effect.
+func runStepsInSingleTransaction(c *Config, db *sql.DB, steps []plannedStep) error {
+	fmt.Println("== Running", len(steps), "migrations in a single transaction ==")
+	t0 := time.Now()
+
+	var err error
+	if c.MigrationDriver == "psql" {
+		err = runStepsInSingleTransactionByPsql(c, steps)
+	} else {
+		err = runStepsInSingleTransactionByPq(c, db, steps)
+	}
+
+	if err != nil {
+		printFailedMigrationMessage(err, MIGRATION)
+		fmt.Fprintln(os.Stderr, "The transaction was rolled back; none of the migrations were applied.")
+		return err
+	}
+
+	fmt.Println("== Completed in", time.Since(t0).Nanoseconds()/1e6, "ms ==")
+	return nil
+}
+
+func runStepsInSingleTransactionByPq(c *Config, db *sql.DB, steps []plannedStep) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if step.Direction == UP {
+			fmt.Println("== Applying", step.Migration.Filename, "==")
+		} else {
+			fmt.Println("== Reverting", step.Migration.Filename, "==")
+		}
+
+		var err error
+		if step.Migration.isGo() {
+			err = execGoMigration(c, tx, step.Migration, step.Direction)
+		} else {
+			var contents []byte
+			contents, err = readMigration(c, step.Migration)
+			if err == nil {
+				err = execMigration(c, tx, step.Migration, contents, recordVersion(c, step.Migration.Version, step.Direction, checksum(contents)))
+			}
+		}
+		if err != nil {
+			tx.Rollback() //nolint:errcheck // already returning an error
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// runStepsInSingleTransactionByPsql combines the steps into one script, and
+// runs it with psql's --single-transaction.
+func runStepsInSingleTransactionByPsql(c *Config, steps []plannedStep) error {
+	if err := c.DumpToEnv(); err != nil {
+		return err
+	}
+
+	tmpfile, err := os.CreateTemp("", "migrations")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name()) //nolint:errcheck // best-effort cleanup
+	defer tmpfile.Close()           //nolint:errcheck // superseded by explicit close below
+
+	for _, step := range steps {
+		body, err := stepBodySQL(c, step)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(tmpfile, "-- == %s ==\n%s\n", step.Migration.Filename, body); err != nil {
+			return err
+		}
+	}
+
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+
+	return sh("psql", []string{"-f", tmpfile.Name(), "-v", "ON_ERROR_STOP=1", "-1"})
+}
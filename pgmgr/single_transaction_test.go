@@ -0,0 +1,23 @@
+package pgmgr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSingleTransaction(t *testing.T) {
+	steps := []plannedStep{
+		{Migration: Migration{Filename: "001_create_foos.up.sql", Version: 1}, Direction: UP},
+		{Migration: Migration{Filename: "002_create_bars.up.sql", Version: 2}, Direction: UP},
+	}
+
+	if err := checkSingleTransaction(steps); err != nil {
+		t.Fatal("expected transactional migrations to be allowed, got", err)
+	}
+
+	steps = append(steps, plannedStep{Migration: Migration{Filename: "003_index_foos.no_txn.up.sql", Version: 3}, Direction: UP})
+	err := checkSingleTransaction(steps)
+	if err == nil || !strings.Contains(err.Error(), "003_index_foos.no_txn.up.sql") {
+		t.Fatal("expected an error listing the .no_txn. migration, got", err)
+	}
+}
@@ -0,0 +1,257 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the directive listing the versions which a squashed baseline replaces
+const squashedDirective = "squashed"
+
+// the default folder, inside MigrationFolder, to archive squashed migrations to
+const archiveFolderName = "archive"
+
+// Squash replaces every migration older than the given version with a single
+// baseline migration, generated from the schema of a scratch database which
+// has been migrated up to that point. The squashed migration files are moved
+// to archiveFolder (default: an "archive" folder inside MigrationFolder).
+//
+// Databases which have already applied the squashed migrations treat the
+// baseline as applied; see Migrate. Only the schema is carried over, so any
+// data inserted by the squashed migrations is not part of the baseline.
+func Squash(c *Config, before int64, archiveFolder string) error {
+	if err := checkMigrationFolderWritable(c); err != nil {
+		return err
+	}
+
+	if archiveFolder == "" {
+		archiveFolder = filepath.Join(c.MigrationFolder, archiveFolderName)
+	}
+
+	if err := checkArchiveFolder(c, archiveFolder); err != nil {
+		return err
+	}
+
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return err
+	}
+
+	downs, err := migrations(c, "down")
+	if err != nil {
+		return err
+	}
+
+	toArchive := []Migration{}
+	versions := map[int64]bool{}
+	latest := int64(-1)
+	for _, m := range ups {
+		if m.Version >= before {
+			continue
+		}
+
+		if len(m.Environments) > 0 {
+			return fmt.Errorf("cannot squash %s, as it is only for some environments", m.Filename)
+		}
+
+		if m.isGo() {
+			return fmt.Errorf("cannot squash %s, as it is a Go migration", m.Filename)
+		}
+
+		contents, err := readMigration(c, m)
+		if err != nil {
+			return err
+		}
+
+		// squashing an earlier baseline replaces whatever it replaced, too
+		for _, v := range squashedVersions(contents) {
+			versions[v] = true
+		}
+
+		versions[m.Version] = true
+		toArchive = append(toArchive, m)
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+
+	if len(toArchive) == 0 {
+		return fmt.Errorf("no migrations older than version %d to squash", before)
+	}
+
+	for _, m := range downs {
+		if m.Version < before && len(m.Environments) == 0 {
+			toArchive = append(toArchive, m)
+		}
+	}
+
+	var schema string
+	err = withScratchDatabase(c, func(scratch *Config) error {
+		if err := MigrateTo(scratch, latest); err != nil {
+			return err
+		}
+
+		dump, err := rawSchemaDump(scratch)
+		schema = baselineSQL(dump)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(archiveFolder, 0755); err != nil {
+		return err
+	}
+
+	for _, m := range toArchive {
+		if err := os.Rename(filepath.Join(c.MigrationFolder, m.Filename), filepath.Join(archiveFolder, m.Filename)); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Moved", len(toArchive), "squashed migration files to", archiveFolder)
+
+	sorted := make([]int64, 0, len(versions))
+	for v := range versions {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	squashed := make([]string, len(sorted))
+	for i, v := range sorted {
+		squashed[i] = strconv.FormatInt(v, 10)
+	}
+
+	header := fmt.Sprintf(
+		"%s%s=%s\n-- Baseline generated by `pgmgr migration squash`, replacing the migrations\n"+
+			"-- listed above, which were moved to %s. Databases which have applied any\n"+
+			"-- of them treat this migration as already applied.\n\n",
+		directivePrefix, squashedDirective, strings.Join(squashed, ","), archiveFolder,
+	)
+
+	baselinePath := filepath.Join(c.MigrationFolder, fmt.Sprint(latest, "_squashed_baseline.up.sql"))
+	if err := os.WriteFile(baselinePath, []byte(header+schema), 0644); err != nil {
+		return err
+	}
+	fmt.Println("Created", baselinePath)
+
+	return nil
+}
+
+// squashedVersions returns the versions replaced by a squashed baseline
+// migration, or none if the migration is not a squashed baseline.
+func squashedVersions(contents []byte) []int64 {
+	value, ok := readDirectives(contents)[squashedDirective]
+	if !ok {
+		return nil
+	}
+
+	versions := []int64{}
+	for _, v := range strings.Split(value, ",") {
+		if version, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+var (
+	createSchemaRegex = regexp.MustCompile(`(?m)^CREATE SCHEMA `)
+	setRegex          = regexp.MustCompile(`(?m)^SET `)
+	searchPathRegex   = regexp.MustCompile(`(?m)^SELECT pg_catalog\.set_config\('search_path'.*$`)
+	metaCommandRegex  = regexp.MustCompile(`(?m)^\\.*$`)
+)
+
+// baselineSQL adapts a schema dump to run as a migration: settings are made
+// local to the migration's transaction, rather than lingering in the session
+// (in particular the empty search_path, which would hide the migration
+// table), and schemas which may already exist are created only if missing.
+func baselineSQL(dump string) string {
+	dump = metaCommandRegex.ReplaceAllString(dump, "")
+	dump = searchPathRegex.ReplaceAllString(dump, "")
+	dump = setRegex.ReplaceAllString(dump, "SET LOCAL ")
+	return createSchemaRegex.ReplaceAllString(dump, "CREATE SCHEMA IF NOT EXISTS ")
+}
+
+// satisfySquashedBaselines records each pending squashed baseline as applied,
+// without running it, if the database has already applied the migrations it
+// replaces. It returns the applied versions including those. A database which
+// has applied only some of them can't be brought up to date by either
+// running or skipping the baseline, so is refused.
+func satisfySquashedBaselines(c *Config, db *sql.DB, ups []Migration, applied []int64) ([]int64, error) {
+	isApplied := map[int64]bool{}
+	for _, v := range applied {
+		isApplied[v] = true
+	}
+
+	for _, m := range ups {
+		if isApplied[m.Version] {
+			continue
+		}
+
+		contents, err := readMigration(c, m)
+		if err != nil {
+			return applied, err
+		}
+
+		satisfied := false
+		missing := []string{}
+		for _, v := range squashedVersions(contents) {
+			if isApplied[v] {
+				satisfied = true
+			} else {
+				missing = append(missing, strconv.FormatInt(v, 10))
+			}
+		}
+
+		if !satisfied {
+			continue
+		}
+
+		if len(missing) > 0 {
+			return applied, fmt.Errorf(
+				"%s replaces versions which this database has not applied: %s. Apply them from the archived migrations first, or if the schema already matches the baseline, mark it as applied with `pgmgr db migrate --fake %d`",
+				m.Filename, strings.Join(missing, ", "), m.Version,
+			)
+		}
+
+		switch {
+		case c.DryRun && c.DryRunSQL:
+			fmt.Printf("-- == Would mark %s as applied, as it replaces applied migrations ==\n%s\n\n", m.Filename, markVersionSQL(c, m.Version, checksum(contents)))
+		case c.DryRun:
+			fmt.Println("== Would mark", m.Filename, "as applied, as it replaces applied migrations ==")
+		default:
+			if err := insertSchemaVersion(c, db, m.Version, checksum(contents), notExecuted); err != nil {
+				return applied, err
+			}
+			fmt.Println("== Marked", m.Filename, "as applied, as it replaces applied migrations ==")
+		}
+
+		applied = append(applied, m.Version)
+	}
+
+	return applied, nil
+}
+
+// checkArchiveFolder returns an error if migrations are read from the given
+// archive folder, as the squashed migrations would then clash with the
+// baseline replacing them.
+func checkArchiveFolder(c *Config, archiveFolder string) error {
+	folders, err := environmentFolders(c)
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range append([]string{""}, folders...) {
+		if filepath.Clean(filepath.Join(c.MigrationFolder, folder)) == filepath.Clean(archiveFolder) {
+			return fmt.Errorf("cannot archive squashed migrations to %s, as migrations are read from it", archiveFolder)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package pgmgr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSquashedVersions(t *testing.T) {
+	if versions := squashedVersions([]byte("CREATE TABLE foos (foo_id INTEGER);")); versions != nil {
+		t.Fatal("expected no squashed versions for a plain migration, got", versions)
+	}
+
+	versions := squashedVersions([]byte("-- pgmgr:squashed=1,2,30\n\nCREATE TABLE foos (foo_id INTEGER);"))
+	if !reflect.DeepEqual(versions, []int64{1, 2, 30}) {
+		t.Fatal("expected squashed versions [1 2 30], got", versions)
+	}
+}
+
+func TestCheckArchiveFolder(t *testing.T) {
+	clearMigrationFolder(t)
+
+	c := globalConfig()
+	c.EnvironmentFolders = []string{"old"}
+	if err := os.Mkdir(filepath.Join(migrationFolder, "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeMigration(t, "old/001_create_foos.up.sql", ``)
+
+	if err := checkArchiveFolder(c, migrationFolder+"old"); err == nil {
+		t.Fatal("expected an error archiving to an environment folder")
+	}
+	if err := checkArchiveFolder(c, migrationFolder); err == nil {
+		t.Fatal("expected an error archiving to the migration folder")
+	}
+	if err := checkArchiveFolder(c, migrationFolder+archiveFolderName); err != nil {
+		t.Fatal("expected the default archive folder to be allowed, got", err)
+	}
+}
+
+func TestBaselineSQL(t *testing.T) {
+	dump := `\restrict abc123
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+CREATE SCHEMA app;
+CREATE TABLE app.foos (
+    foo_id integer
+);
+\unrestrict abc123
+`
+
+	baseline := baselineSQL(dump)
+
+	for _, unexpected := range []string{`\restrict`, `\unrestrict`, "search_path", "\nSET statement_timeout"} {
+		if strings.Contains(baseline, unexpected) {
+			t.Fatalf("expected baseline not to contain %q, got:\n%s", unexpected, baseline)
+		}
+	}
+
+	for _, expected := range []string{"SET LOCAL statement_timeout = 0;", "CREATE SCHEMA IF NOT EXISTS app;", "CREATE TABLE app.foos"} {
+		if !strings.Contains(baseline, expected) {
+			t.Fatalf("expected baseline to contain %q, got:\n%s", expected, baseline)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package pgmgr
+
+import "sort"
+
+// MigrationStatus describes the state of a single migration version, as
+// seen by comparing the MigrationFolder against the migration table.
+type MigrationStatus struct {
+	Migration
+
+	// Applied is true if the version is recorded in the migration table.
+	Applied bool
+	// AppliedOrder is the position of the version in the order migrations
+	// were applied, starting from 1, or 0 if it has not been applied.
+	AppliedOrder int
+	// HasDown is true if a matching .down.sql file exists.
+	HasDown bool
+	// Orphaned is true if the version is recorded in the migration table
+	// but no migration file for it exists in MigrationFolder.
+	Orphaned bool
+	// Backdated is true if the migration is pending but older than the
+	// current Version, e.g. because it was merged in from another branch.
+	Backdated bool
+	// Skipped is true if the migration is pending but not for the configured
+	// environment, so won't be applied.
+	Skipped bool
+}
+
+// Status returns the state of every migration in MigrationFolder, along with
+// any orphaned versions found in the migration table, sorted by version.
+func Status(c *Config) ([]MigrationStatus, error) {
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return nil, err
+	}
+
+	downs, err := migrations(c, "down")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openConnection(c)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+
+	versions, err := appliedVersionsInOrder(c, db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]bool{}
+	appliedOrder := map[int64]int{}
+	current := int64(-1)
+	for i, v := range versions {
+		applied[v] = true
+		appliedOrder[v] = i + 1
+		if v > current {
+			current = v
+		}
+	}
+
+	hasDown := map[int64]bool{}
+	for _, m := range downs {
+		hasDown[m.Version] = true
+	}
+
+	statuses := []MigrationStatus{}
+	seen := map[int64]bool{}
+	for _, m := range ups {
+		seen[m.Version] = true
+
+		// versions replaced by a squashed baseline aren't orphaned
+		contents, err := readMigration(c, m)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range squashedVersions(contents) {
+			seen[v] = true
+		}
+
+		skipped := !applied[m.Version] && !m.AppliesTo(c.Environment)
+		statuses = append(statuses, MigrationStatus{
+			Migration:    m,
+			Applied:      applied[m.Version],
+			AppliedOrder: appliedOrder[m.Version],
+			HasDown:      hasDown[m.Version],
+			Backdated:    !applied[m.Version] && !skipped && m.Version < current,
+			Skipped:      skipped,
+		})
+	}
+
+	for _, v := range versions {
+		if !seen[v] {
+			statuses = append(statuses, MigrationStatus{
+				Migration:    Migration{Version: v},
+				Applied:      true,
+				AppliedOrder: appliedOrder[v],
+				HasDown:      hasDown[v],
+				Orphaned:     true,
+			})
+		}
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
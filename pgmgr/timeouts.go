@@ -0,0 +1,57 @@
+package pgmgr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// timeoutSettings maps each Postgres timeout setting to its value for a
+// migration, as given by its directives or else by the config.
+var timeoutSettings = []struct {
+	name  string
+	value func(c *Config, m Migration) string
+}{
+	{"lock_timeout", func(c *Config, m Migration) string { return firstNonEmpty(m.LockTimeout, c.LockTimeout) }},
+	{"statement_timeout", func(c *Config, m Migration) string { return firstNonEmpty(m.StatementTimeout, c.StatementTimeout) }},
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// timeoutSQL returns the statements to run before a migration to apply its
+// timeouts, and those to run after it to put them back. Timeouts are SET
+// LOCAL when the migration runs in a transaction. They only need putting
+// back if that transaction isn't the migration's own, as it is otherwise
+// discarded with it.
+func timeoutSQL(c *Config, m Migration) (before, after string) {
+	inTransaction := m.WrapInTransaction() || c.SingleTransaction
+	ownTransaction := m.WrapInTransaction() && !c.SingleTransaction
+
+	set, reset := []string{}, []string{}
+	for _, setting := range timeoutSettings {
+		value := setting.value(c, m)
+		if value == "" {
+			continue
+		}
+
+		scope := ""
+		if inTransaction {
+			scope = "LOCAL "
+		}
+		set = append(set, fmt.Sprintf("SET %s%s = %s;", scope, setting.name, pq.QuoteLiteral(value)))
+
+		if !ownTransaction {
+			reset = append(reset, fmt.Sprintf("RESET %s;", setting.name))
+		}
+	}
+
+	return strings.Join(set, "\n"), strings.Join(reset, "\n")
+}
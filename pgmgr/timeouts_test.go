@@ -0,0 +1,34 @@
+package pgmgr
+
+import "testing"
+
+func TestTimeoutSQL(t *testing.T) {
+	c := &Config{StatementTimeout: "10min"}
+
+	before, after := timeoutSQL(c, Migration{Filename: "001_create_foos.up.sql", LockTimeout: "5s"})
+	if before != "SET LOCAL lock_timeout = '5s';\nSET LOCAL statement_timeout = '10min';" {
+		t.Fatal("expected timeouts to be set locally in the migration's transaction, got", before)
+	}
+	if after != "" {
+		t.Fatal("expected no reset for a migration with its own transaction, got", after)
+	}
+
+	before, after = timeoutSQL(c, Migration{Filename: "001_create_foos.no_txn.up.sql", LockTimeout: "5s"})
+	if before != "SET lock_timeout = '5s';\nSET statement_timeout = '10min';" {
+		t.Fatal("expected timeouts to be set for the session without a transaction, got", before)
+	}
+	if after != "RESET lock_timeout;\nRESET statement_timeout;" {
+		t.Fatal("expected timeouts to be reset after a migration without a transaction, got", after)
+	}
+
+	c.SingleTransaction = true
+	before, after = timeoutSQL(c, Migration{Filename: "001_create_foos.up.sql", StatementTimeout: "1min"})
+	if before != "SET LOCAL statement_timeout = '1min';" || after != "RESET statement_timeout;" {
+		t.Fatal("expected timeouts to be set locally and reset in a single transaction, got", before, after)
+	}
+
+	before, after = timeoutSQL(&Config{}, Migration{Filename: "001_create_foos.up.sql"})
+	if before != "" || after != "" {
+		t.Fatal("expected no timeouts without a directive or default, got", before, after)
+	}
+}
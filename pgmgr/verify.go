@@ -0,0 +1,114 @@
+package pgmgr
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ChecksumMismatch describes an applied migration whose file no longer
+// matches the checksum recorded when it was applied.
+type ChecksumMismatch struct {
+	Migration
+	Recorded string
+	Actual   string
+}
+
+// Verify compares every applied migration in MigrationFolder against the
+// checksum recorded when it was applied, and returns those which have since
+// been modified. Migrations applied before checksums were recorded, squashed
+// baselines, and applied versions with no migration file are not checked.
+func Verify(c *Config) ([]ChecksumMismatch, error) {
+	ups, err := migrations(c, "up")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openConnection(c)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+
+	return checksumMismatches(c, db, ups)
+}
+
+func checksumMismatches(c *Config, db *sql.DB, ups []Migration) ([]ChecksumMismatch, error) {
+	recorded, err := appliedChecksums(c, db)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := []ChecksumMismatch{}
+	for _, m := range ups {
+		sum, ok := recorded[m.Version]
+		if !ok {
+			continue
+		}
+
+		contents, err := readMigration(c, m)
+		if err != nil {
+			return nil, err
+		}
+
+		// a squashed baseline may stand in for the migration which was
+		// originally applied with its version, so can't be compared.
+		if len(squashedVersions(contents)) > 0 {
+			continue
+		}
+
+		if actual := checksum(contents); actual != sum {
+			mismatches = append(mismatches, ChecksumMismatch{Migration: m, Recorded: sum, Actual: actual})
+		}
+	}
+
+	return mismatches, nil
+}
+
+// appliedChecksums returns the recorded checksum of every applied version
+// which has one.
+func appliedChecksums(c *Config, db *sql.DB) (map[int64]string, error) {
+	checksums := map[int64]string{}
+
+	exists, err := migrationTableExists(c, db)
+	if err != nil || !exists {
+		return checksums, err
+	}
+
+	columns, err := migrationTableColumnNames(c, db)
+	if err != nil || !columns["checksum"] {
+		return checksums, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT version, checksum FROM %s WHERE checksum IS NOT NULL`,
+		c.quotedMigrationTable(),
+	))
+	if err != nil {
+		return checksums, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var version int64
+		var sum string
+		if err := rows.Scan(&version, &sum); err != nil {
+			return checksums, err
+		}
+		checksums[version] = sum
+	}
+
+	return checksums, rows.Err()
+}
+
+func modifiedMigrationsError(mismatches []ChecksumMismatch) error {
+	filenames := make([]string, len(mismatches))
+	for i, m := range mismatches {
+		filenames[i] = m.Filename
+	}
+
+	return fmt.Errorf(
+		"these migrations have been modified since they were applied (use --allow-modified to migrate anyway):\n  %s",
+		strings.Join(filenames, "\n  "),
+	)
+}